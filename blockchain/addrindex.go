@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// AddrIndexName is the name of the address index.
+const AddrIndexName = "addrindex"
+
+var addrIndexPrefix = []byte("ad-")
+
+// AddressTx is a transaction of the active chain that paid an address,
+// spent from it, or both.
+type AddressTx struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int
+	Position  int
+	Received  int
+	Sent      int
+}
+
+// AddrIndex records, for every address, the transactions touching it in
+// chain order.
+type AddrIndex struct{}
+
+func init() {
+	registerIndex(AddrIndex{})
+}
+
+func (AddrIndex) Name() string {
+	return AddrIndexName
+}
+
+func (AddrIndex) ConnectBlock(txn StoreTxn, block *Block, undo *BlockUndo) error {
+	for key, entry := range addressTxs(block, undo) {
+		var buffer bytes.Buffer
+		if err := gob.NewEncoder(&buffer).Encode(entry); err != nil {
+			return err
+		}
+
+		if err := txn.Set(addrIndexKey(key.address, entry.Height, entry.Position), buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (AddrIndex) DisconnectBlock(txn StoreTxn, block *Block, undo *BlockUndo) error {
+	for key, entry := range addressTxs(block, undo) {
+		if err := txn.Delete(addrIndexKey(key.address, entry.Height, entry.Position)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type addrTxKey struct {
+	address  string
+	position int
+}
+
+// addressTxs totals what each transaction of block paid to and spent from
+// every address it touches. The spent outputs come from undo, whose entries
+// follow the order of the block's inputs.
+func addressTxs(block *Block, undo *BlockUndo) map[addrTxKey]*AddressTx {
+	entries := make(map[addrTxKey]*AddressTx)
+	spent := undo.Spent
+
+	entry := func(address string, position int) *AddressTx {
+		key := addrTxKey{address, position}
+		if entries[key] == nil {
+			tx := block.Transactions[position]
+			entries[key] = &AddressTx{TxID: tx.ID, BlockHash: block.Hash, Height: block.Height, Position: position}
+		}
+		return entries[key]
+	}
+
+	for i, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for range tx.Inputs {
+				if len(spent) == 0 {
+					break
+				}
+				entry(spent[0].PubKey, i).Sent += spent[0].Value
+				spent = spent[1:]
+			}
+		}
+
+		for _, out := range tx.Outputs {
+			entry(out.PubKey, i).Received += out.Value
+		}
+	}
+
+	return entries
+}
+
+// addrIndexKey orders the entries of an address by height and position.
+// The zero byte keeps one address from being the prefix of another.
+func addrIndexKey(address string, height, position int) []byte {
+	key := append(addrKeyPrefix(address), ToHex(int64(height))...)
+	return append(key, ToHex(int64(position))...)
+}
+
+func addrKeyPrefix(address string) []byte {
+	key := append(append([]byte{}, addrIndexPrefix...), address...)
+	return append(key, 0)
+}
+
+// AddressHistory returns up to count transactions touching address, oldest
+// first, skipping the first from, along with how many the index holds for
+// the address.
+func (chain *BlockChain) AddressHistory(address string, from, count int) ([]AddressTx, int, error) {
+	if !chain.HasIndex(AddrIndexName) {
+		return nil, 0, fmt.Errorf("%s is not enabled", AddrIndexName)
+	}
+
+	var history []AddressTx
+	total := 0
+
+	err := chain.Store.View(func(txn StoreTxn) error {
+		return txn.Iterate(addrKeyPrefix(address), func(_, value []byte) error {
+			total++
+			if total <= from || len(history) >= count {
+				return nil
+			}
+
+			var entry AddressTx
+			if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&entry); err != nil {
+				return err
+			}
+			history = append(history, entry)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return history, total, nil
+}
@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// BadgerStore keeps the chain in a badger database on disk.
+type BadgerStore struct {
+	DB *badger.DB
+}
+
+// OpenBadgerStore opens the database in dir, creating it if needed.
+func OpenBadgerStore(dir string) (*BadgerStore, error) {
+	opts := badger.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+
+	db, err := openDB(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BadgerStore{db}, nil
+}
+
+func DBexists(path string) bool {
+	_, err := os.Stat(path + "/MANIFEST")
+	return !os.IsNotExist(err)
+}
+
+func openDB(dir string, opts badger.Options) (*badger.DB, error) {
+	if db, err := badger.Open(opts); err != nil {
+		if strings.Contains(err.Error(), "LOCK") {
+			if db, err := retry(dir, opts); err == nil {
+				log.Println("database unlocked, value log truncated")
+				return db, nil
+			}
+			log.Println("could not unlock database:", err)
+		}
+		return nil, err
+	} else {
+		return db, nil
+	}
+}
+
+func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
+	lockPath := filepath.Join(dir, "LOCK")
+	if err := os.Remove(lockPath); err != nil {
+		return nil, fmt.Errorf(`removing "LOCK": %s`, err)
+	}
+	retryOpts := originalOpts
+	retryOpts.Truncate = true
+	db, err := badger.Open(retryOpts)
+	return db, err
+}
+
+func (store *BadgerStore) View(fn func(txn StoreTxn) error) error {
+	return store.DB.View(func(txn *badger.Txn) error {
+		return fn(chainTxn{badgerTxn{txn}})
+	})
+}
+
+func (store *BadgerStore) Update(fn func(txn StoreTxn) error) error {
+	return store.DB.Update(func(txn *badger.Txn) error {
+		return fn(chainTxn{badgerTxn{txn}})
+	})
+}
+
+func (store *BadgerStore) Close() error {
+	return store.DB.Close()
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t badgerTxn) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+func (t badgerTxn) Set(key, value []byte) error {
+	return t.txn.Set(key, value)
+}
+
+func (t badgerTxn) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t badgerTxn) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	it := t.txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,49 +2,179 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/gob"
 	"log"
-	"time"
 )
 
+const BlockVersion = 1
+
+// BlockHeader holds every consensus field of a block. The block hash is the
+// hash of the whole header except Signature, so none of the other values
+// can be altered without sealing the block again.
+type BlockHeader struct {
+	Version    int
+	PrevHash   []byte
+	MerkleRoot []byte
+	Timestamp  int64
+	Bits       uint32
+	Nonce      uint32
+	Height     int
+	// StakeTxID and StakeOut name the output staked for a proof-of-stake
+	// block. StakeValue, StakeHeight and StakePubKey repeat the value, the
+	// creation height and the owner of that output, so the kernel and the
+	// signature can be checked before the UTXO set is at the parent. They
+	// are left out of Bytes when StakeTxID is empty.
+	StakeTxID   []byte
+	StakeOut    int
+	StakeValue  int
+	StakeHeight int
+	StakePubKey string
+	// Signature is the seal of engines that sign blocks. It signs the
+	// hash, so it cannot be part of it.
+	Signature []byte
+}
+
 type Block struct {
-	Timestamp    int64
+	BlockHeader
 	Hash         []byte
 	Transactions []*Transaction
-	PrevHash     []byte
-	Nonce        int
-	Height       int
+}
+
+func (header *BlockHeader) Bytes() []byte {
+	fields := [][]byte{
+		ToHex(int64(header.Version)),
+		header.PrevHash,
+		header.MerkleRoot,
+		ToHex(header.Timestamp),
+		ToHex(int64(header.Bits)),
+		ToHex(int64(header.Nonce)),
+		ToHex(int64(header.Height)),
+	}
+	if len(header.StakeTxID) > 0 {
+		// The lengths keep bytes from moving between StakeTxID, the
+		// numbers and StakePubKey without changing the hash.
+		fields = append(fields,
+			ToHex(int64(len(header.StakeTxID))),
+			header.StakeTxID,
+			ToHex(int64(header.StakeOut)),
+			ToHex(int64(header.StakeValue)),
+			ToHex(int64(header.StakeHeight)),
+			ToHex(int64(len(header.StakePubKey))),
+			[]byte(header.StakePubKey),
+		)
+	}
+
+	return bytes.Join(fields, []byte{})
+}
+
+func (header *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(header.Bytes())
+
+	return hash[:]
+}
+
+func (header *BlockHeader) Serialize() []byte {
+	var res bytes.Buffer
+
+	encoder := gob.NewEncoder(&res)
+
+	err := encoder.Encode(header)
+	Handle(err)
+
+	return res.Bytes()
+}
+
+func DeserializeHeader(data []byte) *BlockHeader {
+	var header BlockHeader
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+
+	err := decoder.Decode(&header)
+	Handle(err)
+
+	return &header
 }
 
 func (block *Block) HashTransactions() []byte {
 	var txHashes [][]byte
-	var txHash [32]byte
 
 	for _, tx := range block.Transactions {
 		txHashes = append(txHashes, tx.ID)
 	}
 
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
+	return MerkleRoot(txHashes)
+}
 
-	return txHash[:]
+// Size is the length of the fixed encoding of the header plus that of every
+// transaction. Unlike the gob encoding it is the same in every process.
+func (block *Block) Size() int {
+	size := len(block.BlockHeader.Bytes()) + len(block.Signature)
+	for _, tx := range block.Transactions {
+		size += tx.Size()
+	}
+
+	return size
 }
 
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), []byte{}, txs, prevHash, 0, height}
+func (block *Block) SigOpCount() int {
+	sigOps := 0
+	for _, tx := range block.Transactions {
+		sigOps += tx.SigOpCount()
+	}
 
-	pow := NewProof(block)
+	return sigOps
+}
 
-	nonce, hash := pow.Run()
+// CreateBlock assembles txs under header and seals the result with engine.
+// The header must already be prepared by the same engine.
+func CreateBlock(ctx context.Context, engine ConsensusEngine, txs []*Transaction, header BlockHeader) (*Block, error) {
+	block := &Block{BlockHeader: header, Transactions: txs}
+	block.Version = BlockVersion
+	block.MerkleRoot = block.HashTransactions()
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+	if err := engine.Seal(ctx, block); err != nil {
+		return nil, err
+	}
+	block.Hash = block.BlockHeader.Hash()
 
-	return block
+	return block, nil
 }
 
-func Genesis(cbTx *Transaction) *Block {
-	return CreateBlock([]*Transaction{cbTx}, []byte{}, 0)
+// withExtraNonce returns a copy of block whose coinbase carries extraNonce,
+// with the merkle root updated to match. The other transactions are shared.
+func (block *Block) withExtraNonce(extraNonce uint64) *Block {
+	coinbase := *block.Transactions[0]
+	coinbase.Inputs = append([]TxInput{}, coinbase.Inputs...)
+	coinbase.Inputs[0].ExtraNonce = extraNonce
+	coinbase.ID = coinbase.Hash()
+
+	candidate := *block
+	candidate.Transactions = append([]*Transaction{&coinbase}, block.Transactions[1:]...)
+	candidate.MerkleRoot = candidate.HashTransactions()
+
+	return &candidate
+}
+
+// GenesisBlock builds the genesis block of a network from its parameters.
+// Its nonce is precomputed, so no mining happens here.
+func GenesisBlock(params *ChainParams) *Block {
+	cbTx := CoinbaseTx(params.GenesisAddress, params.GenesisData, params.BlockReward)
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:   BlockVersion,
+			PrevHash:  []byte{},
+			Timestamp: params.GenesisTimestamp,
+			Bits:      params.PowLimitBits,
+			Nonce:     params.GenesisNonce,
+		},
+		Transactions: []*Transaction{cbTx},
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = block.BlockHeader.Hash()
+
+	return block
 }
 
 func (block *Block) Serialize() []byte {
@@ -59,14 +189,23 @@ func (block *Block) Serialize() []byte {
 }
 
 func Deserialize(data []byte) *Block {
+	block, err := DecodeBlock(data)
+	Handle(err)
+
+	return block
+}
+
+// DecodeBlock is Deserialize for data from the network. It returns an error
+// instead of panicking when data is not a block.
+func DecodeBlock(data []byte) (*Block, error) {
 	var block Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&block); err != nil {
+		return nil, err
+	}
 
-	err := decoder.Decode(&block)
-	Handle(err)
-
-	return &block
+	return &block, nil
 }
 
 func Handle(err error) {
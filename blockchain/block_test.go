@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeBlock(t *testing.T) {
+	_, block := newTestChain(t, "alice")
+
+	decoded, err := DecodeBlock(block.Serialize())
+	if err != nil {
+		t.Fatalf("DecodeBlock of a serialized block = %v", err)
+	}
+	if !bytes.Equal(decoded.Hash, block.Hash) || len(decoded.Transactions) != len(block.Transactions) {
+		t.Errorf("DecodeBlock = %x with %d txs, want %x with %d", decoded.Hash, len(decoded.Transactions), block.Hash, len(block.Transactions))
+	}
+
+	for _, data := range [][]byte{nil, []byte("not a block"), block.Serialize()[:20]} {
+		if _, err := DecodeBlock(data); err == nil {
+			t.Errorf("DecodeBlock(%q) returned no error", data)
+		}
+	}
+
+	if _, err := DecodeTransaction([]byte("not a tx")); err == nil {
+		t.Error("DecodeTransaction of garbage returned no error")
+	}
+}
+
+func TestStakeFieldsHashUnambiguously(t *testing.T) {
+	a := BlockHeader{
+		StakeTxID:   []byte("staked tx"),
+		StakeOut:    1,
+		StakeValue:  2,
+		StakeHeight: 3,
+		StakePubKey: "0123456789abcdef0123456789abcdef-owner",
+	}
+
+	// b moves the numbers of a into its StakeTxID and takes its own from
+	// the start of StakePubKey, which spells the same bytes unprefixed.
+	b := a
+	b.StakeTxID = append(append(append(append([]byte{}, a.StakeTxID...),
+		ToHex(int64(a.StakeOut))...),
+		ToHex(int64(a.StakeValue))...),
+		ToHex(int64(a.StakeHeight))...)
+	pubKey := []byte(a.StakePubKey)
+	b.StakeOut = int(binary.BigEndian.Uint64(pubKey[0:8]))
+	b.StakeValue = int(binary.BigEndian.Uint64(pubKey[8:16]))
+	b.StakeHeight = int(binary.BigEndian.Uint64(pubKey[16:24]))
+	b.StakePubKey = a.StakePubKey[24:]
+
+	if bytes.Equal(a.Hash(), b.Hash()) {
+		t.Error("headers with different stake fields hash the same")
+	}
+}
@@ -1,226 +1,354 @@
 package blockchain
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"math/big"
 	"runtime"
-	"strings"
-
-	"github.com/dgraph-io/badger"
+	"sync"
 )
 
-const (
-	DbPath      = "./tmp/blocks_%s"
-	genesisData = "1st Coinbase transaction in Genesis block"
+var (
+	blockPrefix     = []byte("b-")
+	headerPrefix    = []byte("h-")
+	heightPrefix    = []byte("hi-")
+	chainWorkPrefix = []byte("cw-")
 )
 
 type BlockChain struct {
 	LastHash []byte
-	Database *badger.DB
-}
-
-func DBexists(path string) bool {
-	_, err := os.Stat(path + "/MANIFEST")
-	return !os.IsNotExist(err)
-}
+	Store    ChainStore
+	Engine   ConsensusEngine
 
-func openDB(dir string, opts badger.Options) (*badger.DB, error) {
-	if db, err := badger.Open(opts); err != nil {
-		if strings.Contains(err.Error(), "LOCK") {
-			if db, err := retry(dir, opts); err == nil {
-				log.Println("database unlocked, value log truncated")
-				return db, nil
-			}
-			log.Println("could not unlock database:", err)
-		}
-		return nil, err
-	} else {
-		return db, nil
-	}
+	// mu serializes AddBlock and guards LastHash, which other goroutines
+	// read through Tip.
+	mu      sync.Mutex
+	indexes []Index
 }
 
-func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
-	lockPath := filepath.Join(dir, "LOCK")
-	if err := os.Remove(lockPath); err != nil {
-		return nil, fmt.Errorf(`removing "LOCK": %s`, err)
-	}
-	retryOpts := originalOpts
-	retryOpts.Truncate = true
-	db, err := badger.Open(retryOpts)
-	return db, err
+// ChainUpdate lists the blocks that left and joined the active chain when
+// AddBlock moved the tip. Disconnected runs from the old tip down to the fork
+// point, Connected from just above the fork point up to the new tip.
+type ChainUpdate struct {
+	Disconnected []*Block
+	Connected    []*Block
 }
 
-func InitBlockChain(address string, nodeId string) *BlockChain {
-	var lastHash []byte
-
-	path := fmt.Sprintf(DbPath, nodeId)
+// InitBlockChain creates a new database holding only the genesis block of
+// the active network.
+func InitBlockChain(nodeId string) *BlockChain {
+	path := DBPath(nodeId)
 
 	if DBexists(path) {
 		fmt.Println("Blockchain already exists!!!")
 		runtime.Goexit()
 	}
 
-	opts := badger.DefaultOptions
-	opts.Dir = path
-	opts.ValueDir = path
+	store, err := OpenBadgerStore(path)
+	Handle(err)
 
-	db, err := openDB(path, opts)
+	chain, err := CreateBlockChain(store)
 	Handle(err)
+	fmt.Println("Genesis Created!!!")
 
-	err = db.Update(func(txn *badger.Txn) error {
-		cbTx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbTx)
-		fmt.Println("Genesis Created!!!")
+	for _, name := range EnableIndexes {
+		Handle(chain.EnableIndex(name))
+	}
 
-		err = txn.Set(genesis.Hash, genesis.Serialize())
-		Handle(err)
+	return chain
+}
 
-		err = txn.Set([]byte("lh"), genesis.Hash)
+func ResumeBlockChain(nodeId string) *BlockChain {
+	path := DBPath(nodeId)
 
-		lastHash = genesis.Hash
+	if !DBexists(path) {
+		fmt.Println("No existing blockchain found, Just init a new chain!!!")
+		runtime.Goexit()
+	}
 
-		return err
-	})
+	store, err := OpenBadgerStore(path)
 	Handle(err)
 
-	blockchain := BlockChain{lastHash, db}
+	chain, err := OpenBlockChain(store)
+	if err != nil {
+		log.Panicf("database at %s: %s", path, err)
+	}
 
-	return &blockchain
+	for _, name := range EnableIndexes {
+		Handle(chain.EnableIndex(name))
+	}
+
+	return chain
 }
 
-func InitGenesis(genesis Block, nodeId string) *BlockChain {
+// CreateBlockChain writes the genesis block of the active network to an
+// empty store and adds its outputs to the UTXO set.
+func CreateBlockChain(store ChainStore) (*BlockChain, error) {
+	genesis := GenesisBlock(ActiveNetParams)
+	if hex.EncodeToString(genesis.Hash) != ActiveNetParams.GenesisHash {
+		return nil, fmt.Errorf("genesis block of %s hashes to %x, want %s", ActiveNetParams.Name, genesis.Hash, ActiveNetParams.GenesisHash)
+	}
 
-	path := fmt.Sprintf(DbPath, nodeId)
+	err := store.Update(func(txn StoreTxn) error {
+		if err := txn.PutBlock(genesis); err != nil {
+			return err
+		}
+		if err := txn.SetChainWork(genesis.Hash, CalcWork(genesis.Bits)); err != nil {
+			return err
+		}
+		if err := txn.SetHashByHeight(genesis.Height, genesis.Hash); err != nil {
+			return err
+		}
+		if err := (&UTXOSet{}).update(txn, genesis); err != nil {
+			return err
+		}
 
-	if DBexists(path) {
-		fmt.Println("Blockchain already exists!!!")
-		runtime.Goexit()
+		return txn.SetTip(genesis.Hash)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("Genesis Received!!!")
+	return &BlockChain{LastHash: genesis.Hash, Store: store, Engine: NewEngine(ActiveNetParams)}, nil
+}
 
-	opts := badger.DefaultOptions
-	opts.Dir = path
-	opts.ValueDir = path
+// OpenBlockChain resumes the chain kept in store, which has to start with
+// the genesis block of the active network. A store written before the
+// height index existed gets it built here.
+func OpenBlockChain(store ChainStore) (*BlockChain, error) {
+	var lastHash []byte
+	var indexes []Index
 
-	db, err := openDB(path, opts)
-	Handle(err)
+	err := store.Update(func(txn StoreTxn) error {
+		genesisHash, err := hex.DecodeString(ActiveNetParams.GenesisHash)
+		if err != nil {
+			return err
+		}
 
-	err = db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.GetHeader(genesisHash); err != nil {
+			return fmt.Errorf("store does not hold the %s genesis block", ActiveNetParams.Name)
+		}
 
-		err = txn.Set(genesis.Hash, genesis.Serialize())
-		Handle(err)
+		lastHash, err = txn.GetTip()
+		if err != nil {
+			return err
+		}
 
-		err = txn.Set([]byte("lh"), genesis.Hash)
+		indexes, err = loadIndexes(txn)
+		if err != nil {
+			return err
+		}
 
-		return err
+		return indexHeights(txn, lastHash)
 	})
-	Handle(err)
-
-	blockchain := BlockChain{genesis.Hash, db}
+	if err != nil {
+		return nil, err
+	}
 
-	return &blockchain
+	return &BlockChain{LastHash: lastHash, Store: store, Engine: NewEngine(ActiveNetParams), indexes: indexes}, nil
 }
 
-func ResumeBlockChain(nodeId string) *BlockChain {
-	var lastHash []byte
+// indexHeights fills in the height index from tipHash down to the first
+// height that already points at the right block.
+func indexHeights(txn StoreTxn, tipHash []byte) error {
+	hash := tipHash
 
-	path := fmt.Sprintf(DbPath, nodeId)
+	for len(hash) > 0 {
+		header, err := txn.GetHeader(hash)
+		if err != nil {
+			return err
+		}
 
-	if !DBexists(path) {
-		fmt.Println("No existing blockchain found, Just init a new chain!!!")
-		runtime.Goexit()
+		indexed, err := txn.GetHashByHeight(header.Height)
+		if err == nil && bytes.Equal(indexed, hash) {
+			return nil
+		}
+		if err := txn.SetHashByHeight(header.Height, hash); err != nil {
+			return err
+		}
+
+		hash = header.PrevHash
 	}
 
-	opts := badger.DefaultOptions
-	opts.Dir = path
-	opts.ValueDir = path
+	return nil
+}
 
-	db, err := openDB(path, opts)
-	Handle(err)
+// AddBlock stores block and makes it the new tip if its branch carries more
+// cumulative work than the active chain. When that branch forks below the
+// current tip the old blocks are disconnected and the new ones connected in
+// the same transaction as the tip change, and the returned ChainUpdate
+// describes the move. A connected block whose inputs do not check out, or a
+// move that would break a checkpoint, aborts the whole transaction. It
+// returns nil when the tip did not change.
+func (chain *BlockChain) AddBlock(block *Block) (*ChainUpdate, error) {
+	var update *ChainUpdate
+
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	err := chain.Store.Update(func(txn StoreTxn) error {
+		if _, err := txn.GetHeader(block.Hash); err == nil {
+			return nil
+		}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
+		parentWork, err := txn.GetChainWork(block.PrevHash)
+		if err != nil {
+			return fmt.Errorf("parent block %x is not found", block.PrevHash)
+		}
+		work := new(big.Int).Add(parentWork, CalcWork(block.Bits))
 
-		lastHash, err = item.Value()
+		if err := txn.PutBlock(block); err != nil {
+			return err
+		}
+		if err := txn.SetChainWork(block.Hash, work); err != nil {
+			return err
+		}
 
-		return err
-	})
-	Handle(err)
+		lastHash, err := txn.GetTip()
+		if err != nil {
+			return err
+		}
 
-	blockchain := BlockChain{lastHash, db}
+		lastWork, err := txn.GetChainWork(lastHash)
+		if err != nil {
+			return err
+		}
 
-	return &blockchain
-}
+		if !chain.Engine.SelectFork(lastWork, work) {
+			return nil
+		}
 
-func (chain *BlockChain) AddBlock(block *Block) {
+		update, err = findChainUpdate(txn, lastHash, block)
+		if err != nil {
+			return err
+		}
 
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get(block.Hash); err == nil {
-			return nil
+		lastHeader, err := txn.GetHeader(lastHash)
+		if err != nil {
+			return err
+		}
+		for _, disconnected := range update.Disconnected {
+			if disconnected.Height <= lastCheckpointHeight(lastHeader.Height) {
+				return fmt.Errorf("%w: would disconnect height %d", ErrForkBeforeCheckpoint, disconnected.Height)
+			}
+		}
+		for _, connected := range update.Connected {
+			if cp, ok := checkpointAt(connected.Height); ok && cp.Hash != hex.EncodeToString(connected.Hash) {
+				return fmt.Errorf("%w: height %d", ErrCheckpointMismatch, connected.Height)
+			}
 		}
 
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		Handle(err)
+		utxoSet := UTXOSet{chain}
+		for _, disconnected := range update.Disconnected {
+			if err := chain.disconnectIndexes(txn, disconnected); err != nil {
+				return err
+			}
+			if err := utxoSet.disconnect(txn, disconnected); err != nil {
+				return err
+			}
+			if err := txn.DeleteHashByHeight(disconnected.Height); err != nil {
+				return err
+			}
+		}
+		for _, connected := range update.Connected {
+			if err := chain.checkConnect(txn, connected); err != nil {
+				return fmt.Errorf("block %x: %w", connected.Hash, err)
+			}
+			if err := utxoSet.update(txn, connected); err != nil {
+				return err
+			}
+			if err := chain.connectIndexes(txn, connected); err != nil {
+				return err
+			}
+			if err := txn.SetHashByHeight(connected.Height, connected.Hash); err != nil {
+				return err
+			}
+		}
 
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, _ := item.Value()
+		return txn.SetTip(block.Hash)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		lastBlockData, _ := item.Value()
+	if update != nil {
+		chain.LastHash = block.Hash
+	}
+
+	return update, nil
+}
+
+// findChainUpdate walks the old tip and the new block back to their common
+// ancestor and collects the blocks on either side of it.
+func findChainUpdate(txn StoreTxn, oldHash []byte, newBlock *Block) (*ChainUpdate, error) {
+	update := &ChainUpdate{}
+
+	oldHeader, err := txn.GetHeader(oldHash)
+	if err != nil {
+		return nil, err
+	}
+	newHash := newBlock.Hash
+	newHeader := &newBlock.BlockHeader
+
+	for newHeader.Height > oldHeader.Height || !bytes.Equal(oldHash, newHash) {
+		if newHeader.Height >= oldHeader.Height {
+			block, err := txn.GetBlock(newHash)
+			if err != nil {
+				return nil, err
+			}
+			update.Connected = append([]*Block{block}, update.Connected...)
 
-		lastBlock := Deserialize(lastBlockData)
+			newHash = newHeader.PrevHash
+			if newHeader, err = txn.GetHeader(newHash); err != nil {
+				return nil, err
+			}
+		} else {
+			block, err := txn.GetBlock(oldHash)
+			if err != nil {
+				return nil, err
+			}
+			update.Disconnected = append(update.Disconnected, block)
 
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			Handle(err)
-			chain.LastHash = block.Hash
+			oldHash = oldHeader.PrevHash
+			if oldHeader, err = txn.GetHeader(oldHash); err != nil {
+				return nil, err
+			}
 		}
+	}
 
-		return nil
-	})
-	Handle(err)
+	return update, nil
 }
 
 func (chain *BlockChain) GetBestHeight() int {
-	var lastBlock Block
+	var lastHeader *BlockHeader
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, _ := item.Value()
-
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		lastBlockData, _ := item.Value()
+	err := chain.Store.View(func(txn StoreTxn) error {
+		lastHash, err := txn.GetTip()
+		if err != nil {
+			return err
+		}
 
-		lastBlock = *Deserialize(lastBlockData)
+		lastHeader, err = txn.GetHeader(lastHash)
 
-		return nil
+		return err
 	})
 	Handle(err)
 
-	return lastBlock.Height
+	return lastHeader.Height
 }
 
 func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		if item, err := txn.Get(blockHash); err != nil {
+	err := chain.Store.View(func(txn StoreTxn) error {
+		if b, err := txn.GetBlock(blockHash); err != nil {
 			return errors.New("Block is not found")
 		} else {
-			blockData, _ := item.Value()
-
-			block = *Deserialize(blockData)
+			block = *b
 		}
 		return nil
 	})
@@ -231,17 +359,50 @@ func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
+func (chain *BlockChain) HasBlock(blockHash []byte) bool {
+	err := chain.Store.View(func(txn StoreTxn) error {
+		_, err := txn.GetHeader(blockHash)
+		return err
+	})
+
+	return err == nil
+}
+
+// Tip returns the hash of the last block of the active chain.
+func (chain *BlockChain) Tip() []byte {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	return chain.LastHash
+}
+
+func (chain *BlockChain) GetHeader(blockHash []byte) (BlockHeader, error) {
+	var header BlockHeader
+
+	err := chain.Store.View(func(txn StoreTxn) error {
+		if h, err := txn.GetHeader(blockHash); err != nil {
+			return errors.New("Header is not found")
+		} else {
+			header = *h
+		}
+		return nil
+	})
+
+	return header, err
+}
+
 func (chain *BlockChain) GetBlockHashes() [][]byte {
 	var blocks [][]byte
 
-	iter := chain.Iterator()
+	iter := chain.HeaderIterator()
 
 	for {
-		block := iter.Next()
+		hash := iter.CurrentHash
+		header := iter.Next()
 
-		blocks = append(blocks, block.Hash)
+		blocks = append(blocks, hash)
 
-		if len(block.PrevHash) == 0 {
+		if len(header.PrevHash) == 0 {
 			break
 		}
 	}
@@ -250,21 +411,45 @@ func (chain *BlockChain) GetBlockHashes() [][]byte {
 }
 
 func (chain *BlockChain) GetGenesisBlock() Block {
+	block, err := chain.GetBlockByHeight(0)
+	Handle(err)
 
-	iter := chain.Iterator()
+	return block
+}
 
-	for {
-		block := iter.Next()
+// GetBlockByHeight returns the block of the active chain at height.
+func (chain *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	var block Block
 
-		if len(block.PrevHash) == 0 {
-			return *block
+	err := chain.Store.View(func(txn StoreTxn) error {
+		hash, err := txn.GetHashByHeight(height)
+		if err != nil {
+			return fmt.Errorf("no block at height %d", height)
 		}
-	}
+
+		b, err := txn.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		block = *b
+
+		return nil
+	})
+
+	return block, err
 }
 
-func (chain *BlockChain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
-	var lastHeight int
+// ErrStaleTip is returned by MineBlock when the tip moved while the block
+// was sealed, so it was only stored on a side branch.
+var ErrStaleTip = errors.New("mined block did not become the tip")
+
+// MineBlock builds a block of transactions on top of the tip, seals it
+// with the chain's engine and adds it to the chain. It returns the block
+// along with how the active chain moved, or ErrStaleTip if the block did
+// not become the tip. Sealing stops with the context's error once ctx is
+// done.
+func (chain *BlockChain) MineBlock(ctx context.Context, transactions []*Transaction) (*Block, *ChainUpdate, error) {
+	var header BlockHeader
 
 	// for _, tx := range transactions {
 	// 	if chain.VerifyTransaction(tx) != true {
@@ -272,98 +457,46 @@ func (chain *BlockChain) MineBlock(transactions []*Transaction) *Block {
 	// 	}
 	// }
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
+	err := chain.Store.View(func(txn StoreTxn) error {
+		lastHash, err := txn.GetTip()
 		Handle(err)
-		lastHash, err = item.Value()
 
-		item, err = txn.Get(lastHash)
+		lastHeader, err := txn.GetHeader(lastHash)
 		Handle(err)
-		lastBlockData, _ := item.Value()
-
-		lastBlock := Deserialize(lastBlockData)
-
-		lastHeight = lastBlock.Height
-
-		return err
-	})
-	Handle(err)
-
-	newBlock := CreateBlock(transactions, lastHash, lastHeight+1)
-
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
-		chain.LastHash = newBlock.Hash
-		return err
-	})
-	Handle(err)
-	return newBlock
-}
-
-func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
-	UTXO := make(map[string]TxOutputs)
-	spentTXOs := make(map[string][]int)
-
-	iter := chain.Iterator()
-
-	for {
-		block := iter.Next()
-
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Outputs {
-				if spentTXOs[txID] != nil {
-					for _, spentOut := range spentTXOs[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
-					}
-				}
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
-			}
 
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					inTxID := hex.EncodeToString(in.ID)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
-				}
-			}
+		mtp, err := medianTimePast(txn, lastHeader)
+		if err != nil {
+			return err
 		}
 
-		if len(block.PrevHash) == 0 {
-			break
+		header.PrevHash = lastHash
+		header.Height = lastHeader.Height + 1
+		header.Timestamp = AdjustedTime()
+		if header.Timestamp <= mtp {
+			header.Timestamp = mtp + 1
 		}
 
+		return chain.Engine.Prepare(txn, &header)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return UTXO
-}
-
-// func (chain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-// 	iter := chain.Iterator()
-
-// 	for {
-// 		block := iter.Next()
-
-// 		for _, tx := range block.Transactions {
-// 			if bytes.Compare(tx.ID, ID) == 0 {
-// 				return *tx, nil
-// 			}
-// 		}
+	newBlock, err := CreateBlock(ctx, chain.Engine, transactions, header)
+	if err != nil {
+		return nil, nil, err
+	}
 
-// 		if len(block.PrevHash) == 0 {
-// 			break
-// 		}
-// 	}
+	update, err := chain.AddBlock(newBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+	if update == nil {
+		return newBlock, nil, fmt.Errorf("%w: %x", ErrStaleTip, newBlock.Hash)
+	}
 
-// 	return Transaction{}, errors.New("Transaction does not exist")
-// }
+	return newBlock, update, nil
+}
 
 // func (chain *BlockChain) VerifyTransaction(tx *Transaction) bool {
 // 	prevTxs := make(map[string]Transaction)
@@ -1,14 +1,12 @@
 package blockchain
 
-import "github.com/dgraph-io/badger"
-
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Store       ChainStore
 }
 
 func (chain *BlockChain) Iterator() *BlockChainIterator {
-	iter := &BlockChainIterator{chain.LastHash, chain.Database}
+	iter := &BlockChainIterator{chain.Tip(), chain.Store}
 
 	return iter
 }
@@ -16,11 +14,9 @@ func (chain *BlockChain) Iterator() *BlockChainIterator {
 func (iter *BlockChainIterator) Next() *Block {
 	var block *Block
 
-	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		Handle(err)
-		encodedBlock, err := item.Value()
-		block = Deserialize(encodedBlock)
+	err := iter.Store.View(func(txn StoreTxn) error {
+		var err error
+		block, err = txn.GetBlock(iter.CurrentHash)
 
 		return err
 	})
@@ -30,3 +26,32 @@ func (iter *BlockChainIterator) Next() *Block {
 
 	return block
 }
+
+// HeaderIterator walks the chain backwards like BlockChainIterator but only
+// decodes headers, leaving the transaction bodies on disk.
+type HeaderIterator struct {
+	CurrentHash []byte
+	Store       ChainStore
+}
+
+func (chain *BlockChain) HeaderIterator() *HeaderIterator {
+	iter := &HeaderIterator{chain.Tip(), chain.Store}
+
+	return iter
+}
+
+func (iter *HeaderIterator) Next() *BlockHeader {
+	var header *BlockHeader
+
+	err := iter.Store.View(func(txn StoreTxn) error {
+		var err error
+		header, err = txn.GetHeader(iter.CurrentHash)
+
+		return err
+	})
+	Handle(err)
+
+	iter.CurrentHash = header.PrevHash
+
+	return header
+}
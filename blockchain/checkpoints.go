@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Checkpoint pins the block hash expected at a height. The chain must pass
+// through every checkpoint and can never reorganize below the last one it
+// reached.
+type Checkpoint struct {
+	Height int
+	Hash   string
+}
+
+var (
+	ErrCheckpointMismatch   = errors.New("block does not match the checkpoint at its height")
+	ErrForkBeforeCheckpoint = errors.New("branch forks below the last checkpoint")
+)
+
+// checkpointAt returns the checkpoint pinned at height, if any.
+func checkpointAt(height int) (Checkpoint, bool) {
+	for _, cp := range ActiveNetParams.Checkpoints {
+		if cp.Height == height {
+			return cp, true
+		}
+	}
+
+	if av := ActiveNetParams.AssumeValid; av.Hash != "" && av.Height == height {
+		return av, true
+	}
+
+	return Checkpoint{}, false
+}
+
+// lastCheckpointHeight returns the height of the highest checkpoint at or
+// below tipHeight, or -1 if the chain has not reached any yet.
+func lastCheckpointHeight(tipHeight int) int {
+	last := -1
+
+	for _, cp := range ActiveNetParams.Checkpoints {
+		if cp.Height <= tipHeight && cp.Height > last {
+			last = cp.Height
+		}
+	}
+
+	if av := ActiveNetParams.AssumeValid; av.Hash != "" && av.Height <= tipHeight && av.Height > last {
+		last = av.Height
+	}
+
+	return last
+}
+
+// checkCheckpoint verifies that a block at height with hash fits the
+// checkpoints, given the height of the current tip.
+func checkCheckpoint(height int, hash []byte, tipHeight int) error {
+	if cp, ok := checkpointAt(height); ok && cp.Hash != hex.EncodeToString(hash) {
+		return fmt.Errorf("%w: height %d", ErrCheckpointMismatch, height)
+	}
+
+	if last := lastCheckpointHeight(tipHeight); height <= last {
+		return fmt.Errorf("%w: height %d, checkpoint at %d", ErrForkBeforeCheckpoint, height, last)
+	}
+
+	return nil
+}
+
+// isAssumedValid reports whether the unlock checks of block can be skipped,
+// which holds for the ancestors of the assume-valid block only. Until that
+// block is stored nothing is known to be its ancestor, so nothing is
+// skipped.
+func isAssumedValid(txn StoreTxn, block *Block) bool {
+	av := ActiveNetParams.AssumeValid
+	if av.Hash == "" || block.Height > av.Height {
+		return false
+	}
+
+	avHash, err := hex.DecodeString(av.Hash)
+	if err != nil {
+		return false
+	}
+
+	avHeader, err := txn.GetHeader(avHash)
+	if err != nil {
+		return false
+	}
+
+	ancestor, err := ancestorHeader(txn, avHeader, block.Height)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(ancestor.Hash(), block.Hash)
+}
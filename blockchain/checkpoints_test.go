@@ -0,0 +1,196 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// setCheckpoints installs checkpoints and an assume-valid block on the
+// active network. The returned function restores the old ones.
+func setCheckpoints(checkpoints []Checkpoint, assumeValid Checkpoint) func() {
+	oldCheckpoints, oldAssumeValid := ActiveNetParams.Checkpoints, ActiveNetParams.AssumeValid
+	ActiveNetParams.Checkpoints, ActiveNetParams.AssumeValid = checkpoints, assumeValid
+
+	return func() {
+		ActiveNetParams.Checkpoints, ActiveNetParams.AssumeValid = oldCheckpoints, oldAssumeValid
+	}
+}
+
+func checkpointOf(block *Block) Checkpoint {
+	return Checkpoint{Height: block.Height, Hash: hex.EncodeToString(block.Hash)}
+}
+
+// mineOn mines a block on parent without adding it to the chain, so it can
+// start or extend a side branch.
+func mineOn(t *testing.T, chain *BlockChain, parent *Block, txs ...*Transaction) *Block {
+	t.Helper()
+
+	header := BlockHeader{PrevHash: parent.Hash, Height: parent.Height + 1}
+	err := chain.Store.View(func(txn StoreTxn) error {
+		mtp, err := medianTimePast(txn, &parent.BlockHeader)
+		if err != nil {
+			return err
+		}
+		header.Timestamp = AdjustedTime()
+		if header.Timestamp <= mtp {
+			header.Timestamp = mtp + 1
+		}
+
+		return chain.Engine.Prepare(txn, &header)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cbTx := CoinbaseTx("miner", "", ActiveNetParams.BlockReward)
+	block, err := CreateBlock(context.Background(), chain.Engine, append([]*Transaction{cbTx}, txs...), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func tipHash(t *testing.T, chain *BlockChain) []byte {
+	t.Helper()
+
+	var hash []byte
+	err := chain.Store.View(func(txn StoreTxn) error {
+		var err error
+		hash, err = txn.GetTip()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	chain, b1 := newTestChain(t, "alice")
+	b2 := mineOn(t, chain, b1)
+	other := mineOn(t, chain, b1)
+
+	defer setCheckpoints([]Checkpoint{checkpointOf(other)}, Checkpoint{})()
+
+	if err := chain.ValidateBlock(b2); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("ValidateBlock of a block off the checkpoint = %v, want %v", err, ErrCheckpointMismatch)
+	}
+	if err := chain.ValidateBlock(other); err != nil {
+		t.Errorf("ValidateBlock of the checkpointed block = %v", err)
+	}
+
+	// The assume-valid block pins its height too.
+	setCheckpoints(nil, checkpointOf(other))
+	if err := chain.ValidateBlock(b2); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("ValidateBlock of a block off the assume-valid block = %v, want %v", err, ErrCheckpointMismatch)
+	}
+}
+
+func TestReorgBelowCheckpoint(t *testing.T) {
+	chain, b1 := newTestChain(t, "alice")
+	mineTestBlock(t, chain, "alice")
+	b3 := mineTestBlock(t, chain, "alice")
+	b4 := mineTestBlock(t, chain, "alice")
+
+	defer setCheckpoints([]Checkpoint{checkpointOf(b3)}, Checkpoint{})()
+
+	// A branch forking below the checkpoint is refused up front.
+	s2 := mineOn(t, chain, b1)
+	if err := chain.ValidateBlock(s2); !errors.Is(err, ErrForkBeforeCheckpoint) {
+		t.Errorf("ValidateBlock of a fork below the checkpoint = %v, want %v", err, ErrForkBeforeCheckpoint)
+	}
+
+	// And the reorganization is refused when such a branch gets stored
+	// anyway and overtakes the chain.
+	side := s2
+	for side.Height <= b4.Height {
+		if _, err := chain.AddBlock(side); err != nil {
+			t.Fatalf("AddBlock of side block %d = %v", side.Height, err)
+		}
+		side = mineOn(t, chain, side)
+	}
+	if _, err := chain.AddBlock(side); !errors.Is(err, ErrForkBeforeCheckpoint) {
+		t.Errorf("AddBlock of a branch forking below the checkpoint = %v, want %v", err, ErrForkBeforeCheckpoint)
+	}
+	if tip := tipHash(t, chain); !bytes.Equal(tip, b4.Hash) {
+		t.Errorf("tip = %x, want %x", tip, b4.Hash)
+	}
+
+	// A fork above the checkpoint is still fine.
+	if err := chain.ValidateBlock(mineOn(t, chain, b3)); err != nil {
+		t.Errorf("ValidateBlock of a fork above the checkpoint = %v", err)
+	}
+}
+
+func TestAssumeValidAncestors(t *testing.T) {
+	chain, b1 := newTestChain(t, "alice")
+	b2 := mineTestBlock(t, chain, "alice")
+
+	// s2 spends b1's coinbase without alice's signature.
+	cbTx := b1.Transactions[0]
+	forged := &Transaction{
+		Inputs:  []TxInput{{ID: cbTx.ID, Out: 0, Sig: "mallory"}},
+		Outputs: []TxOutput{{Value: cbTx.Outputs[0].Value, PubKey: "mallory"}},
+	}
+	forged.ID = forged.Hash()
+	s2 := mineOn(t, chain, b1, forged)
+	if _, err := chain.AddBlock(s2); err != nil {
+		t.Fatal(err)
+	}
+	s3 := mineOn(t, chain, s2)
+
+	if _, err := chain.AddBlock(s3); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("AddBlock of a branch with a forged spend = %v, want %v", err, ErrBadSignature)
+	}
+
+	// Until the assume-valid block is stored nothing is its ancestor.
+	defer setCheckpoints(nil, checkpointOf(s3))()
+	assumedValid := func(block *Block) bool {
+		var ok bool
+		err := chain.Store.View(func(txn StoreTxn) error {
+			ok = isAssumedValid(txn, block)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	for _, block := range []*Block{b1, s2} {
+		if assumedValid(block) {
+			t.Errorf("block %d is assumed valid before the assume-valid block is stored", block.Height)
+		}
+	}
+
+	// The forged spend is let through as an ancestor.
+	if update, err := chain.AddBlock(s3); err != nil || update == nil {
+		t.Fatalf("AddBlock of the branch through the assume-valid block = %v, %v", update, err)
+	}
+	s4 := mineOn(t, chain, s3)
+	for _, block := range []*Block{b1, s2, s3} {
+		if !assumedValid(block) {
+			t.Errorf("ancestor %d of the assume-valid block is not assumed valid", block.Height)
+		}
+	}
+	for _, block := range []*Block{b2, s4} {
+		if assumedValid(block) {
+			t.Errorf("block %d off the assume-valid ancestry is assumed valid", block.Height)
+		}
+	}
+
+	// But not past it.
+	s3Coinbase := s3.Transactions[0]
+	forged = &Transaction{
+		Inputs:  []TxInput{{ID: s3Coinbase.ID, Out: 0, Sig: "mallory"}},
+		Outputs: []TxOutput{{Value: s3Coinbase.Outputs[0].Value, PubKey: "mallory"}},
+	}
+	forged.ID = forged.Hash()
+	if err := chain.ValidateBlock(mineOn(t, chain, s3, forged)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("ValidateBlock of a forged spend past the assume-valid block = %v, want %v", err, ErrBadSignature)
+	}
+}
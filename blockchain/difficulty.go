@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"math/big"
+)
+
+// CompactToBig expands the compact "bits" encoding of a target: the high
+// byte is a base-256 exponent and the low three bytes the mantissa.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	exponent := uint(compact >> 24)
+
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		return big.NewInt(int64(mantissa))
+	}
+
+	target := big.NewInt(int64(mantissa))
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		shifted := new(big.Int).Rsh(target, 8*(exponent-3))
+		mantissa = uint32(shifted.Uint64())
+	}
+
+	// The mantissa's top bit is a sign bit, keep it clear.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	return uint32(exponent<<24) | mantissa
+}
+
+// CalcWork returns the expected number of hashes needed to find a block
+// meeting bits, which is what fork choice sums up along a branch.
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// GetDifficulty reports how many times harder bits is than the easiest
+// allowed target.
+func GetDifficulty(bits uint32) float64 {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	difficulty, _ := new(big.Float).Quo(
+		new(big.Float).SetInt(ActiveNetParams.PowLimit),
+		new(big.Float).SetInt(target),
+	).Float64()
+
+	return difficulty
+}
+
+// CalcNextBits returns the target a block built on top of prevHash has to
+// meet. The target only changes every RetargetInterval blocks, scaled by how
+// long the last interval actually took compared with TargetBlockTime.
+func (chain *BlockChain) CalcNextBits(prevHash []byte) (uint32, error) {
+	var bits uint32
+
+	err := chain.Store.View(func(txn StoreTxn) error {
+		var err error
+		bits, err = calcNextBits(txn, prevHash)
+
+		return err
+	})
+
+	return bits, err
+}
+
+func calcNextBits(txn StoreTxn, prevHash []byte) (uint32, error) {
+	prev, err := txn.GetHeader(prevHash)
+	if err != nil {
+		return 0, err
+	}
+
+	params := ActiveNetParams
+	height := prev.Height + 1
+	if params.NoRetargeting {
+		return params.PowLimitBits, nil
+	}
+	if height%params.RetargetInterval != 0 {
+		return prev.Bits, nil
+	}
+
+	first, err := ancestorHeader(txn, prev, height-params.RetargetInterval)
+	if err != nil {
+		return 0, err
+	}
+
+	expected := params.TargetBlockTime * int64(params.RetargetInterval-1)
+	actual := prev.Timestamp - first.Timestamp
+
+	if actual < expected/params.MaxRetargetFactor {
+		actual = expected / params.MaxRetargetFactor
+	}
+	if actual > expected*params.MaxRetargetFactor {
+		actual = expected * params.MaxRetargetFactor
+	}
+
+	target := CompactToBig(prev.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+
+	if target.Cmp(params.PowLimit) > 0 {
+		target.Set(params.PowLimit)
+	}
+
+	return BigToCompact(target), nil
+}
+
+// ancestorHeader walks back from header to the header at the given height.
+func ancestorHeader(txn StoreTxn, header *BlockHeader, height int) (*BlockHeader, error) {
+	for header.Height > height {
+		prev, err := txn.GetHeader(header.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		header = prev
+	}
+
+	return header, nil
+}
+
+// NetworkHashRate estimates the hashes per second of the whole network from
+// the work of the last blocks blocks and the time between their timestamps.
+func (chain *BlockChain) NetworkHashRate(blocks int) (float64, error) {
+	var rate float64
+
+	err := chain.Store.View(func(txn StoreTxn) error {
+		tipHash, err := txn.GetTip()
+		if err != nil {
+			return err
+		}
+		tip, err := txn.GetHeader(tipHash)
+		if err != nil {
+			return err
+		}
+
+		work := new(big.Int)
+		header := tip
+		for header.Height > 0 && tip.Height-header.Height < blocks {
+			work.Add(work, CalcWork(header.Bits))
+
+			header, err = txn.GetHeader(header.PrevHash)
+			if err != nil {
+				return err
+			}
+		}
+
+		span := tip.Timestamp - header.Timestamp
+		if span <= 0 {
+			return nil
+		}
+
+		rate, _ = new(big.Float).Quo(new(big.Float).SetInt(work), big.NewFloat(float64(span))).Float64()
+
+		return nil
+	})
+
+	return rate, err
+}
@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+)
+
+// retargetParams retargets every 5 blocks aiming at 10 seconds each, so
+// one interval is expected to take 40 seconds between its first and last
+// timestamps, and moves the target at most 4 times either way.
+func retargetParams() *ChainParams {
+	limit := powLimit(8)
+
+	return &ChainParams{
+		PowLimit:          limit,
+		PowLimitBits:      BigToCompact(limit),
+		RetargetInterval:  5,
+		TargetBlockTime:   10,
+		MaxRetargetFactor: 4,
+	}
+}
+
+// storeHeaders stores a chain of headers from height 0 whose timestamps are
+// spacing seconds apart, all with bits, and returns the hash of the last.
+func storeHeaders(t *testing.T, store ChainStore, count int, spacing int64, bits uint32) []byte {
+	t.Helper()
+
+	var prevHash []byte
+	err := store.Update(func(txn StoreTxn) error {
+		for height := 0; height < count; height++ {
+			block := &Block{BlockHeader: BlockHeader{
+				PrevHash:  prevHash,
+				Timestamp: 1700000000 + int64(height)*spacing,
+				Bits:      bits,
+				Height:    height,
+			}}
+			block.Hash = block.BlockHeader.Hash()
+			if err := txn.PutBlock(block); err != nil {
+				return err
+			}
+			prevHash = block.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return prevHash
+}
+
+// scaledBits is the compact form of the target of bits times num over den.
+func scaledBits(bits uint32, num, den int64) uint32 {
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(num))
+	target.Div(target, big.NewInt(den))
+
+	return BigToCompact(target)
+}
+
+func TestCalcNextBits(t *testing.T) {
+	params := retargetParams()
+	old := ActiveNetParams
+	ActiveNetParams = params
+	defer func() { ActiveNetParams = old }()
+
+	hard := BigToCompact(powLimit(20))
+
+	tests := []struct {
+		name    string
+		count   int
+		spacing int64
+		bits    uint32
+		want    uint32
+	}{
+		{"between retargets", 3, 1, hard, hard},
+		{"on schedule", 5, 10, hard, hard},
+		{"twice as fast", 5, 5, hard, scaledBits(hard, 20, 40)},
+		{"twice as slow", 5, 20, hard, scaledBits(hard, 80, 40)},
+		{"clamped when fast", 5, 1, hard, scaledBits(hard, 1, 4)},
+		{"clamped when timestamps go back", 5, -30, hard, scaledBits(hard, 1, 4)},
+		{"clamped when slow", 5, 1000, hard, scaledBits(hard, 4, 1)},
+		{"capped at the limit", 5, 20, params.PowLimitBits, params.PowLimitBits},
+		{"capped near the limit", 5, 40, scaledBits(params.PowLimitBits, 1, 2), params.PowLimitBits},
+	}
+
+	for _, test := range tests {
+		store := NewMemoryStore()
+		prevHash := storeHeaders(t, store, test.count, test.spacing, test.bits)
+
+		var got uint32
+		err := store.View(func(txn StoreTxn) error {
+			var err error
+			got, err = calcNextBits(txn, prevHash)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %08x, want %08x", test.name, got, test.want)
+		}
+	}
+
+	params.NoRetargeting = true
+	store := NewMemoryStore()
+	prevHash := storeHeaders(t, store, 5, 1, hard)
+	err := store.View(func(txn StoreTxn) error {
+		got, err := calcNextBits(txn, prevHash)
+		if got != params.PowLimitBits {
+			t.Errorf("without retargeting: got %08x, want %08x", got, params.PowLimitBits)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	tests := []struct {
+		bits   uint32
+		target *big.Int
+	}{
+		{0x1d00ffff, new(big.Int).Lsh(big.NewInt(0xffff), 208)},
+		{0x03123456, big.NewInt(0x123456)},
+		{0x02123400, big.NewInt(0x1234)},
+		{0x01120000, big.NewInt(0x12)},
+		// The top mantissa bit is a sign bit, so 0x80 takes a byte more.
+		{0x02008000, big.NewInt(0x80)},
+		{0x2100ffff, new(big.Int).Lsh(big.NewInt(0xffff), 240)},
+	}
+
+	for _, test := range tests {
+		if got := CompactToBig(test.bits); got.Cmp(test.target) != 0 {
+			t.Errorf("CompactToBig(%08x) = %x, want %x", test.bits, got, test.target)
+		}
+		if got := BigToCompact(test.target); got != test.bits {
+			t.Errorf("BigToCompact(%x) = %08x, want %08x", test.target, got, test.bits)
+		}
+	}
+
+	if got := BigToCompact(big.NewInt(0)); got != 0 {
+		t.Errorf("BigToCompact(0) = %08x, want 0", got)
+	}
+
+	// Every limit of the networks survives the round trip.
+	for name, params := range Networks {
+		if got := CompactToBig(params.PowLimitBits); got.Cmp(params.PowLimit) != 0 {
+			t.Errorf("%s: PowLimitBits %08x expands to %x, want %x", name, params.PowLimitBits, got, params.PowLimit)
+		}
+	}
+}
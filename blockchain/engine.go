@@ -0,0 +1,54 @@
+package blockchain
+
+import (
+	"context"
+	"math/big"
+)
+
+const (
+	PoWConsensus = "pow"
+	PoAConsensus = "poa"
+	PoSConsensus = "pos"
+)
+
+// ConsensusEngine decides who may produce a block and which branch the
+// chain follows. Rules every engine shares, such as the height, timestamps
+// and transactions of a block, are checked by the chain itself.
+type ConsensusEngine interface {
+	// Prepare fills the consensus fields of a header whose PrevHash,
+	// Height and Timestamp are already set.
+	Prepare(txn StoreTxn, header *BlockHeader) error
+	// Seal does the work or signing that makes a block with a prepared
+	// header valid. It may change the coinbase and the timestamp. It gives
+	// up with the context's error once ctx is done.
+	Seal(ctx context.Context, block *Block) error
+	// VerifyHeader checks the consensus fields of a header whose parent
+	// is stored.
+	VerifyHeader(txn StoreTxn, header *BlockHeader) error
+	// VerifySeal checks what it can of the work or signature of a header
+	// without its parent, so blocks that arrive before their parent can be
+	// screened before they are held.
+	VerifySeal(header *BlockHeader) error
+	// SelectFork reports whether a branch carrying candidateWork should
+	// replace the active chain carrying tipWork.
+	SelectFork(tipWork, candidateWork *big.Int) bool
+}
+
+// UTXOVerifier is implemented by engines whose rules depend on the UTXO set
+// as of the parent of a block. VerifyUTXO runs whenever the inputs of a
+// block are checked, which is when the UTXO set is at its parent.
+type UTXOVerifier interface {
+	VerifyUTXO(txn StoreTxn, block *Block) error
+}
+
+// NewEngine returns the consensus engine named by params.
+func NewEngine(params *ChainParams) ConsensusEngine {
+	switch params.Consensus {
+	case PoAConsensus:
+		return NewPoAEngine(params.Signers)
+	case PoSConsensus:
+		return &PoSEngine{}
+	default:
+		return &PoWEngine{}
+	}
+}
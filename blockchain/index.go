@@ -0,0 +1,163 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+)
+
+var indexMarkerPrefix = []byte("ix-")
+
+// Index is an optional lookup table kept in step with the active chain.
+// ConnectBlock runs after the block's outputs entered the UTXO set and
+// DisconnectBlock before they leave it. undo holds the entries the block
+// spent, in the order its inputs spent them.
+type Index interface {
+	Name() string
+	ConnectBlock(txn StoreTxn, block *Block, undo *BlockUndo) error
+	DisconnectBlock(txn StoreTxn, block *Block, undo *BlockUndo) error
+}
+
+// EnableIndexes names the indexes InitBlockChain and ResumeBlockChain
+// start keeping on top of the ones the store already keeps.
+var EnableIndexes []string
+
+// Indexes are the optional indexes a chain can keep, by name.
+var Indexes = map[string]Index{}
+
+func registerIndex(index Index) {
+	Indexes[index.Name()] = index
+}
+
+func indexMarkerKey(name string) []byte {
+	return append(append([]byte{}, indexMarkerPrefix...), name...)
+}
+
+// loadIndexes returns the indexes store has been keeping, in name order.
+func loadIndexes(txn StoreTxn) ([]Index, error) {
+	var names []string
+
+	err := txn.Iterate(indexMarkerPrefix, func(key, _ []byte) error {
+		names = append(names, string(key[len(indexMarkerPrefix):]))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var indexes []Index
+	for _, name := range names {
+		index, ok := Indexes[name]
+		if !ok {
+			return nil, fmt.Errorf("store keeps unknown index %s", name)
+		}
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+// HasIndex reports whether the chain keeps the index called name.
+func (chain *BlockChain) HasIndex(name string) bool {
+	for _, index := range chain.indexes {
+		if index.Name() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EnableIndex starts keeping the index called name. It is built over the
+// active chain first, one block per transaction. Once enabled, the store
+// keeps the index from then on.
+func (chain *BlockChain) EnableIndex(name string) error {
+	index, ok := Indexes[name]
+	if !ok {
+		return fmt.Errorf("unknown index %s", name)
+	}
+
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	if chain.HasIndex(name) {
+		return nil
+	}
+
+	tip, err := chain.GetHeader(chain.LastHash)
+	if err != nil {
+		return err
+	}
+
+	for height := 0; height <= tip.Height; height++ {
+		err := chain.Store.Update(func(txn StoreTxn) error {
+			hash, err := txn.GetHashByHeight(height)
+			if err != nil {
+				return err
+			}
+			block, err := txn.GetBlock(hash)
+			if err != nil {
+				return err
+			}
+
+			return index.ConnectBlock(txn, block, blockUndo(txn, block))
+		})
+		if err != nil {
+			return fmt.Errorf("building %s index at height %d: %w", name, height, err)
+		}
+	}
+
+	err = chain.Store.Update(func(txn StoreTxn) error {
+		return txn.Set(indexMarkerKey(name), []byte{1})
+	})
+	if err != nil {
+		return err
+	}
+
+	chain.indexes = append(chain.indexes, index)
+	fmt.Printf("Built %s up to height %d\n", name, tip.Height)
+
+	return nil
+}
+
+// blockUndo returns the undo record of block, or an empty one for a block
+// stored without one, like the genesis block of chains created before
+// CreateBlockChain connected it.
+func blockUndo(txn StoreTxn, block *Block) *BlockUndo {
+	undo, err := txn.GetUndo(block.Hash)
+	if err != nil {
+		return &BlockUndo{}
+	}
+
+	return undo
+}
+
+func (chain *BlockChain) connectIndexes(txn StoreTxn, block *Block) error {
+	if len(chain.indexes) == 0 {
+		return nil
+	}
+	undo := blockUndo(txn, block)
+
+	for _, index := range chain.indexes {
+		if err := index.ConnectBlock(txn, block, undo); err != nil {
+			return fmt.Errorf("%s index: %w", index.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (chain *BlockChain) disconnectIndexes(txn StoreTxn, block *Block) error {
+	if len(chain.indexes) == 0 {
+		return nil
+	}
+	undo := blockUndo(txn, block)
+
+	for i := len(chain.indexes) - 1; i >= 0; i-- {
+		if err := chain.indexes[i].DisconnectBlock(txn, block, undo); err != nil {
+			return fmt.Errorf("%s index: %w", chain.indexes[i].Name(), err)
+		}
+	}
+
+	return nil
+}
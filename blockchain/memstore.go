@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps the chain in memory, for tests and tools that do not
+// need it to outlive the process. Committed data is never changed in place:
+// an Update copies it with its writes applied and swaps the copy in, so a
+// View reads the snapshot it started with. Updates run one at a time, so fn
+// must not start another Update.
+type MemoryStore struct {
+	// writeMu is held for the whole of an Update.
+	writeMu sync.Mutex
+	// mu guards data, the latest committed snapshot.
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (store *MemoryStore) snapshot() map[string][]byte {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.data
+}
+
+func (store *MemoryStore) View(fn func(txn StoreTxn) error) error {
+	return fn(chainTxn{&memTxn{data: store.snapshot()}})
+}
+
+func (store *MemoryStore) Update(fn func(txn StoreTxn) error) error {
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+
+	txn := &memTxn{data: store.snapshot(), writes: make(map[string][]byte), writable: true}
+	if err := fn(chainTxn{txn}); err != nil {
+		return err
+	}
+	if len(txn.writes) == 0 {
+		return nil
+	}
+
+	next := make(map[string][]byte, len(txn.data)+len(txn.writes))
+	for key, value := range txn.data {
+		next[key] = value
+	}
+	for key, value := range txn.writes {
+		if value == nil {
+			delete(next, key)
+		} else {
+			next[key] = value
+		}
+	}
+
+	store.mu.Lock()
+	store.data = next
+	store.mu.Unlock()
+
+	return nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+// memTxn reads the snapshot data and, in an Update, holds the pending
+// writes on top of it. A nil value marks a deleted key.
+type memTxn struct {
+	data     map[string][]byte
+	writes   map[string][]byte
+	writable bool
+}
+
+func (t *memTxn) Get(key []byte) ([]byte, error) {
+	value, ok := t.writes[string(key)]
+	if !ok {
+		value, ok = t.data[string(key)]
+	}
+	if !ok || value == nil {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte{}, value...), nil
+}
+
+func (t *memTxn) Set(key, value []byte) error {
+	if !t.writable {
+		return errReadOnlyTxn
+	}
+	t.writes[string(key)] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (t *memTxn) Delete(key []byte) error {
+	if !t.writable {
+		return errReadOnlyTxn
+	}
+	t.writes[string(key)] = nil
+
+	return nil
+}
+
+// Iterate collects the matching keys first, so fn may read and write the
+// transaction while it runs.
+func (t *memTxn) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	var keys []string
+
+	for key := range t.data {
+		if bytes.HasPrefix([]byte(key), prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range t.writes {
+		if _, ok := t.data[key]; !ok && bytes.HasPrefix([]byte(key), prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := t.Get([]byte(key))
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
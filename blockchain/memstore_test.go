@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMemoryStoreUpdatesDoNotLoseWrites(t *testing.T) {
+	store := NewMemoryStore()
+	key := []byte("counter")
+	const writers = 200
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := store.Update(func(txn StoreTxn) error {
+				count := 0
+				if value, err := txn.Get(key); err == nil {
+					count, _ = strconv.Atoi(string(value))
+				}
+				return txn.Set(key, []byte(strconv.Itoa(count+1)))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := store.View(func(txn StoreTxn) error {
+		value, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		if string(value) != strconv.Itoa(writers) {
+			t.Errorf("counter = %s, want %d", value, writers)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryStoreViewReadsSnapshot(t *testing.T) {
+	store := NewMemoryStore()
+	set := func(key, value string) {
+		err := store.Update(func(txn StoreTxn) error {
+			return txn.Set([]byte(key), []byte(value))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	set("a", "1")
+
+	err := store.View(func(txn StoreTxn) error {
+		set("a", "2")
+		set("b", "2")
+
+		if value, err := txn.Get([]byte("a")); err != nil || !bytes.Equal(value, []byte("1")) {
+			t.Errorf("a = %s, %v; want 1 from the snapshot", value, err)
+		}
+		if _, err := txn.Get([]byte("b")); err != ErrNotFound {
+			t.Errorf("b = %v, want ErrNotFound in the snapshot", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryStoreFailedUpdateWritesNothing(t *testing.T) {
+	store := NewMemoryStore()
+
+	err := store.Update(func(txn StoreTxn) error {
+		if err := txn.Set([]byte("a"), []byte("1")); err != nil {
+			return err
+		}
+		return ErrNotFound
+	})
+	if err != ErrNotFound {
+		t.Fatalf("Update = %v, want %v", err, ErrNotFound)
+	}
+
+	err = store.View(func(txn StoreTxn) error {
+		if _, err := txn.Get([]byte("a")); err != ErrNotFound {
+			t.Errorf("a = %v, want ErrNotFound", err)
+		}
+		return txn.Set([]byte("a"), []byte("1"))
+	})
+	if err != errReadOnlyTxn {
+		t.Errorf("Set in View = %v, want %v", err, errReadOnlyTxn)
+	}
+}
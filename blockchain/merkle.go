@@ -0,0 +1,33 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// MerkleRoot folds the given leaf hashes pairwise into a single root hash.
+// An odd node at any level is paired with itself.
+func MerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		root := sha256.Sum256([]byte{})
+		return root[:]
+	}
+
+	level := make([][]byte, len(hashes))
+	copy(level, hashes)
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			hash := sha256.Sum256(bytes.Join([][]byte{level[i], level[i+1]}, []byte{}))
+			next = append(next, hash[:])
+		}
+		level = next
+	}
+
+	return level[0]
+}
@@ -0,0 +1,213 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+// ChainParams groups the values that define a network. Nodes only talk to
+// peers running the same parameters.
+type ChainParams struct {
+	Name string
+	// DataDir is the database path format, filled in with the node id.
+	DataDir         string
+	ProtocolVersion int
+	SeedNodes       []string
+
+	// Consensus names the engine that seals and verifies blocks. Signers
+	// are the hex public keys of the proof-of-authority signer set, in
+	// signing order. They are part of the network, like its genesis block.
+	Consensus string
+	Signers   []string
+
+	// Proof of stake starts after LastPoWHeight. An output stakes once it
+	// is StakeMinAge blocks old and its age counts up to StakeMaxAge.
+	LastPoWHeight   int
+	StakeMinAge     int
+	StakeMaxAge     int
+	StakeTargetBits uint32
+
+	// PowLimit is the easiest target the chain accepts. Retargeting never
+	// goes above it.
+	PowLimit     *big.Int
+	PowLimitBits uint32
+	// RetargetInterval is the number of blocks between difficulty changes.
+	RetargetInterval int
+	// TargetBlockTime is the desired spacing between blocks in seconds.
+	TargetBlockTime int64
+	// MaxRetargetFactor bounds how far a single retarget can move the target.
+	MaxRetargetFactor int64
+	// NoRetargeting keeps every block at PowLimitBits.
+	NoRetargeting bool
+
+	// BlockReward is the amount a coinbase may create on top of the fees.
+	BlockReward int
+
+	// The genesis block is built locally from these values, so every node
+	// of a network starts from the same block. GenesisHash is checked
+	// against the result.
+	GenesisData      string
+	GenesisAddress   string
+	GenesisTimestamp int64
+	GenesisNonce     uint32
+	GenesisHash      string
+
+	// Checkpoints lists known-good blocks of the network, sorted by height.
+	Checkpoints []Checkpoint
+	// AssumeValid names a block whose ancestors are known to carry valid
+	// signatures. It also acts as a checkpoint. A zero Hash disables it.
+	AssumeValid Checkpoint
+}
+
+// powLimit returns the target with the given number of leading zero bits.
+func powLimit(zeroBits uint) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), 256-zeroBits)
+}
+
+var MainNetParams = ChainParams{
+	Name:              "mainnet",
+	DataDir:           "./tmp/blocks_%s",
+	ProtocolVersion:   1,
+	SeedNodes:         []string{"localhost:3000"},
+	Consensus:         PoWConsensus,
+	PowLimit:          powLimit(18),
+	PowLimitBits:      BigToCompact(powLimit(18)),
+	RetargetInterval:  10,
+	TargetBlockTime:   10,
+	MaxRetargetFactor: 4,
+	BlockReward:       33,
+	GenesisData:       "1st Coinbase transaction in Genesis block",
+	GenesisAddress:    "genesis",
+	GenesisTimestamp:  1700000000,
+	GenesisNonce:      345257,
+	GenesisHash:       "00000cdf774931671a3594356f6ec7b18d6ab3139af9d40d8b39ea9d3dad8080",
+	Checkpoints:       []Checkpoint{},
+}
+
+var TestNetParams = ChainParams{
+	Name:              "testnet",
+	DataDir:           "./tmp/testnet/blocks_%s",
+	ProtocolVersion:   1,
+	SeedNodes:         []string{"localhost:3000"},
+	Consensus:         PoWConsensus,
+	PowLimit:          powLimit(16),
+	PowLimitBits:      BigToCompact(powLimit(16)),
+	RetargetInterval:  10,
+	TargetBlockTime:   10,
+	MaxRetargetFactor: 4,
+	BlockReward:       33,
+	GenesisData:       "Testnet genesis block",
+	GenesisAddress:    "genesis",
+	GenesisTimestamp:  1700000001,
+	GenesisNonce:      155085,
+	GenesisHash:       "000039e3308a99d9a4eb913669a9ac53fd91ea714f3581d6a1218713a36f824e",
+	Checkpoints:       []Checkpoint{},
+}
+
+// RegTestParams is meant for local testing: blocks are found almost
+// immediately and the difficulty never changes.
+var RegTestParams = ChainParams{
+	Name:              "regtest",
+	DataDir:           "./tmp/regtest/blocks_%s",
+	ProtocolVersion:   1,
+	SeedNodes:         []string{"localhost:3000"},
+	Consensus:         PoWConsensus,
+	PowLimit:          powLimit(1),
+	PowLimitBits:      BigToCompact(powLimit(1)),
+	RetargetInterval:  10,
+	TargetBlockTime:   10,
+	MaxRetargetFactor: 4,
+	NoRetargeting:     true,
+	BlockReward:       33,
+	GenesisData:       "Regtest genesis block",
+	GenesisAddress:    "genesis",
+	GenesisTimestamp:  1700000002,
+	GenesisNonce:      4,
+	GenesisHash:       "013ad5867ef8045a825cd0c8545e2f0c58aad4bc628cbc0facb99f3b45875c79",
+	Checkpoints:       []Checkpoint{},
+}
+
+// PoANetParams is a permissioned network sealed by proof of authority. The
+// keys of its two test signers are in blockchain/testdata.
+var PoANetParams = ChainParams{
+	Name:            "poa",
+	DataDir:         "./tmp/poa/blocks_%s",
+	ProtocolVersion: 1,
+	SeedNodes:       []string{"localhost:3000"},
+	Consensus:       PoAConsensus,
+	Signers: []string{
+		"0417013110aab27893cbed3a259ad7436d257d0df095d2a8a50b90e711e9ba83d1a63f5206fd83beed58d0d9ae7b671f213ac95e82bd8ed41b17a241b0b1095b93",
+		"049e2245c2d5807f7b9adbfbe0e3ce0cb61a2e077fb5bb4e92cc9ef96a2c36c9fa2dd800b4b3a45e5e3bb17d59a903d58ffcf8fa2293d1992263df351ce26ee27f",
+	},
+	PowLimit:          powLimit(1),
+	PowLimitBits:      BigToCompact(powLimit(1)),
+	RetargetInterval:  10,
+	TargetBlockTime:   10,
+	MaxRetargetFactor: 4,
+	NoRetargeting:     true,
+	BlockReward:       33,
+	GenesisData:       "Proof of authority genesis block",
+	GenesisAddress:    "genesis",
+	GenesisTimestamp:  1700000003,
+	GenesisNonce:      1,
+	GenesisHash:       "253d8d26677bdd75834dd3e13892fcf358f6baaa3bad6d1325ead75fa1a4cfea",
+	Checkpoints:       []Checkpoint{},
+}
+
+// PoSNetParams is the proof-of-stake test network. The first blocks are
+// mined cheaply with proof of work so there are coins to stake.
+var PoSNetParams = ChainParams{
+	Name:              "pos",
+	DataDir:           "./tmp/pos/blocks_%s",
+	ProtocolVersion:   1,
+	SeedNodes:         []string{"localhost:3000"},
+	Consensus:         PoSConsensus,
+	LastPoWHeight:     10,
+	StakeMinAge:       2,
+	StakeMaxAge:       50,
+	StakeTargetBits:   BigToCompact(powLimit(12)),
+	PowLimit:          powLimit(1),
+	PowLimitBits:      BigToCompact(powLimit(1)),
+	RetargetInterval:  10,
+	TargetBlockTime:   10,
+	MaxRetargetFactor: 4,
+	NoRetargeting:     true,
+	BlockReward:       33,
+	GenesisData:       "Proof of stake genesis block",
+	GenesisAddress:    "genesis",
+	GenesisTimestamp:  1700000004,
+	GenesisNonce:      0,
+	GenesisHash:       "0c0ebb19a8ffb18ca3759a466e630fdfed0254c092251f21f50f2ea7ba86d4ff",
+	Checkpoints:       []Checkpoint{},
+}
+
+// Networks holds every registered network by name.
+var Networks = map[string]*ChainParams{
+	MainNetParams.Name: &MainNetParams,
+	TestNetParams.Name: &TestNetParams,
+	RegTestParams.Name: &RegTestParams,
+	PoANetParams.Name:  &PoANetParams,
+	PoSNetParams.Name:  &PoSNetParams,
+}
+
+// ActiveNetParams are the parameters of the network this process runs on.
+var ActiveNetParams = &MainNetParams
+
+// SelectNetwork makes the named network the active one.
+func SelectNetwork(name string) error {
+	params, ok := Networks[name]
+	if !ok {
+		return fmt.Errorf("unknown network %q", name)
+	}
+	ActiveNetParams = params
+	return nil
+}
+
+// DBPath returns the database directory of a node on the active network.
+func DBPath(nodeId string) string {
+	path := fmt.Sprintf(ActiveNetParams.DataDir, nodeId)
+	Handle(os.MkdirAll(filepath.Dir(path), 0755))
+	return path
+}
@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// newPoATestChain returns a poa chain kept in memory and the keys of its
+// signers, loaded from testdata in signing order. The returned function
+// puts back the regtest network.
+func newPoATestChain(t *testing.T) (*BlockChain, []*ecdsa.PrivateKey, func()) {
+	t.Helper()
+
+	if err := SelectNetwork(PoANetParams.Name); err != nil {
+		t.Fatal(err)
+	}
+	reset := func() {
+		SignerKey = nil
+		SelectNetwork(RegTestParams.Name)
+	}
+
+	var keys []*ecdsa.PrivateKey
+	for i, signer := range ActiveNetParams.Signers {
+		key, err := LoadSignerKey(fmt.Sprintf("testdata/poa-signer-%d.pem", i))
+		if err != nil {
+			reset()
+			t.Fatal(err)
+		}
+		if SignerPubKey(key) != signer {
+			reset()
+			t.Fatalf("testdata key %d is not signer %s", i, signer)
+		}
+		keys = append(keys, key)
+	}
+
+	chain, err := CreateBlockChain(NewMemoryStore())
+	if err != nil {
+		reset()
+		t.Fatal(err)
+	}
+
+	return chain, keys, reset
+}
+
+func TestPoAInTurnSealing(t *testing.T) {
+	chain, keys, reset := newPoATestChain(t)
+	defer reset()
+
+	for height := 1; height <= 2*len(keys); height++ {
+		SignerKey = keys[(height+1)%len(keys)]
+		cbTx := CoinbaseTx("alice", "", ActiveNetParams.BlockReward)
+		if _, _, err := chain.MineBlock(context.Background(), []*Transaction{cbTx}); !errors.Is(err, ErrNotInTurn) {
+			t.Fatalf("MineBlock out of turn at height %d = %v, want %v", height, err, ErrNotInTurn)
+		}
+
+		SignerKey = keys[height%len(keys)]
+		block := mineTestBlock(t, chain, "alice")
+		if block.Height != height {
+			t.Fatalf("mined height %d, want %d", block.Height, height)
+		}
+		if err := chain.Engine.VerifySeal(&block.BlockHeader); err != nil {
+			t.Errorf("VerifySeal of in-turn block %d = %v", height, err)
+		}
+	}
+}
+
+func TestPoAWrongSigner(t *testing.T) {
+	chain, keys, reset := newPoATestChain(t)
+	defer reset()
+
+	SignerKey = keys[1]
+	parent := mineTestBlock(t, chain, "alice")
+
+	// The block at height 2 is signer 0's to seal.
+	SignerKey = keys[0]
+	block := mineOn(t, chain, parent)
+	if err := chain.ValidateBlock(block); err != nil {
+		t.Fatalf("ValidateBlock of the in-turn block = %v", err)
+	}
+
+	outsider, err := NewSignerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []*ecdsa.PrivateKey{keys[1], outsider} {
+		forged := *block
+		if err := signHeader(key, &forged.BlockHeader); err != nil {
+			t.Fatal(err)
+		}
+		if err := chain.ValidateBlock(&forged); !errors.Is(err, ErrBadSeal) {
+			t.Errorf("ValidateBlock of a block signed by %s = %v, want %v", SignerPubKey(key), err, ErrBadSeal)
+		}
+	}
+
+	// The signature covers the whole header.
+	forged := *block
+	forged.Timestamp++
+	forged.Hash = forged.BlockHeader.Hash()
+	if err := chain.ValidateBlock(&forged); !errors.Is(err, ErrBadSeal) {
+		t.Errorf("ValidateBlock of an altered header = %v, want %v", err, ErrBadSeal)
+	}
+}
@@ -0,0 +1,319 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrNoStake        = errors.New("no stake kernel found")
+	ErrBadStake       = errors.New("staked output is not usable")
+	ErrBadStakeKernel = errors.New("stake kernel does not meet the target")
+)
+
+// PoSEngine is the proof-of-stake consensus. Blocks up to LastPoWHeight are
+// mined with proof of work to spread the first coins. After that a block
+// stakes an unspent output: its kernel hash has to fall below the stake
+// target scaled by the value and age of that output, and the block is
+// signed by the key whose hex public key is the output's address. The block
+// must spend the output, so its age starts over: Seal adds a coinstake
+// transaction paying it back to its owner unless a transaction of the block
+// already spends it.
+type PoSEngine struct {
+	pow PoWEngine
+}
+
+// stake is an output a staker can try kernels with.
+type stake struct {
+	txID   []byte
+	out    int
+	entry  UTXOEntry
+	weight *big.Int
+}
+
+func isStakeHeight(height int) bool {
+	return height > ActiveNetParams.LastPoWHeight
+}
+
+// stakeWeight is value times age in blocks, with the age capped at
+// StakeMaxAge. It is zero until the output is StakeMinAge blocks old, which
+// a staked output starts over from, as the block spends it.
+func stakeWeight(entry *UTXOEntry, height int) *big.Int {
+	age := height - entry.Height
+	if age < ActiveNetParams.StakeMinAge {
+		return big.NewInt(0)
+	}
+	if age > ActiveNetParams.StakeMaxAge {
+		age = ActiveNetParams.StakeMaxAge
+	}
+
+	return new(big.Int).Mul(big.NewInt(int64(entry.Value)), big.NewInt(int64(age)))
+}
+
+// stakeKernel hashes what a staker cannot choose freely: the parent, the
+// staked output and the block time.
+func stakeKernel(header *BlockHeader) *big.Int {
+	hash := sha256.Sum256(bytes.Join(
+		[][]byte{
+			header.PrevHash,
+			header.StakeTxID,
+			ToHex(int64(header.StakeOut)),
+			ToHex(header.Timestamp),
+		},
+		[]byte{},
+	))
+
+	return new(big.Int).SetBytes(hash[:])
+}
+
+func checkStakeKernel(header *BlockHeader, weight *big.Int) bool {
+	target := new(big.Int).Mul(CompactToBig(header.Bits), weight)
+
+	return stakeKernel(header).Cmp(target) < 0
+}
+
+// findStakes returns the outputs of address that can stake at height.
+func findStakes(txn StoreTxn, address string, height int) []stake {
+	var stakes []stake
+
+	err := txn.ForEachUTXO(func(txID []byte, out int, entry UTXOEntry) error {
+		if entry.PubKey != address {
+			return nil
+		}
+
+		weight := stakeWeight(&entry, height)
+		if weight.Sign() == 0 {
+			return nil
+		}
+
+		stakes = append(stakes, stake{txID, out, entry, weight})
+
+		return nil
+	})
+	Handle(err)
+
+	return stakes
+}
+
+// Prepare picks a stake of SignerKey whose kernel meets the target. It tries
+// every second from the header time up to TargetBlockTime later and returns
+// ErrNoStake when nothing hits, so the caller can try again later.
+func (engine *PoSEngine) Prepare(txn StoreTxn, header *BlockHeader) error {
+	if !isStakeHeight(header.Height) {
+		return engine.pow.Prepare(txn, header)
+	}
+
+	header.Bits = ActiveNetParams.StakeTargetBits
+
+	if SignerKey == nil {
+		return ErrNoSignerKey
+	}
+	stakes := findStakes(txn, SignerPubKey(SignerKey), header.Height)
+
+	start := header.Timestamp
+	for header.Timestamp = start; header.Timestamp <= start+ActiveNetParams.TargetBlockTime; header.Timestamp++ {
+		for _, s := range stakes {
+			header.StakeTxID = s.txID
+			header.StakeOut = s.out
+			header.StakeValue = s.entry.Value
+			header.StakeHeight = s.entry.Height
+			header.StakePubKey = s.entry.PubKey
+
+			if checkStakeKernel(header, s.weight) {
+				return nil
+			}
+		}
+	}
+
+	header.Timestamp = start
+	header.StakeTxID = nil
+	header.StakeOut = 0
+	header.StakeValue = 0
+	header.StakeHeight = 0
+	header.StakePubKey = ""
+
+	return fmt.Errorf("%w: %d outputs at height %d", ErrNoStake, len(stakes), header.Height)
+}
+
+func (engine *PoSEngine) Seal(ctx context.Context, block *Block) error {
+	if !isStakeHeight(block.Height) {
+		return engine.pow.Seal(ctx, block)
+	}
+
+	if SignerKey == nil {
+		return ErrNoSignerKey
+	}
+
+	if !spendsStake(block) && len(block.Transactions) > 0 {
+		txs := []*Transaction{block.Transactions[0], coinstakeTx(&block.BlockHeader)}
+		block.Transactions = append(txs, block.Transactions[1:]...)
+		block.MerkleRoot = block.HashTransactions()
+	}
+
+	return signHeader(SignerKey, &block.BlockHeader)
+}
+
+// coinstakeTx spends the output header stakes and pays it back to its owner.
+func coinstakeTx(header *BlockHeader) *Transaction {
+	tx := &Transaction{
+		Inputs:  []TxInput{{ID: header.StakeTxID, Out: header.StakeOut, Sig: header.StakePubKey}},
+		Outputs: []TxOutput{{Value: header.StakeValue, PubKey: header.StakePubKey}},
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+// spendsStake reports whether a transaction of block spends the output its
+// header stakes.
+func spendsStake(block *Block) bool {
+	for _, tx := range block.Transactions {
+		for _, in := range tx.Inputs {
+			if bytes.Equal(in.ID, block.StakeTxID) && in.Out == block.StakeOut {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// VerifyHeader checks what it can without the UTXO set: the seal, and that
+// the staked output was created on the block's own branch as the header
+// describes it. Whether it is still unspent is checked by VerifyUTXO.
+func (engine *PoSEngine) VerifyHeader(txn StoreTxn, header *BlockHeader) error {
+	if err := engine.VerifySeal(header); err != nil {
+		return err
+	}
+
+	if !isStakeHeight(header.Height) {
+		return engine.pow.VerifyHeader(txn, header)
+	}
+
+	return checkStakeOnBranch(txn, header)
+}
+
+// checkStakeOnBranch looks up the block at StakeHeight on the branch header
+// builds on and makes sure it created the staked output with the value and
+// owner the header claims.
+func checkStakeOnBranch(txn StoreTxn, header *BlockHeader) error {
+	hash, err := branchHashAt(txn, header.PrevHash, header.StakeHeight)
+	if err != nil {
+		return fmt.Errorf("%w: no block at height %d on the branch", ErrBadStake, header.StakeHeight)
+	}
+	block, err := txn.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, header.StakeTxID) {
+			continue
+		}
+		if header.StakeOut < 0 || header.StakeOut >= len(tx.Outputs) {
+			break
+		}
+
+		out := tx.Outputs[header.StakeOut]
+		if out.Value == header.StakeValue && out.PubKey == header.StakePubKey {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %x:%d is not created at height %d on the branch", ErrBadStake, header.StakeTxID, header.StakeOut, header.StakeHeight)
+}
+
+// branchHashAt returns the hash of the block at height on the branch that
+// ends with hash. Once the walk back reaches the active chain, the height
+// index answers the rest.
+func branchHashAt(txn StoreTxn, hash []byte, height int) ([]byte, error) {
+	for {
+		header, err := txn.GetHeader(hash)
+		if err != nil {
+			return nil, err
+		}
+		if header.Height < height {
+			return nil, ErrNotFound
+		}
+
+		if active, err := txn.GetHashByHeight(header.Height); err == nil && bytes.Equal(active, hash) {
+			return txn.GetHashByHeight(height)
+		}
+		if header.Height == height {
+			return hash, nil
+		}
+
+		hash = header.PrevHash
+	}
+}
+
+// VerifySeal checks the proof of work of blocks up to LastPoWHeight. For
+// later ones it checks the kernel against the stake the header claims and
+// the signature of the key the header names. VerifyHeader then ties that
+// claim to the branch.
+func (engine *PoSEngine) VerifySeal(header *BlockHeader) error {
+	if !isStakeHeight(header.Height) {
+		if len(header.StakeTxID) > 0 || len(header.Signature) > 0 {
+			return fmt.Errorf("%w: proof-of-work block carries a stake", ErrBadStake)
+		}
+		return engine.pow.VerifySeal(header)
+	}
+
+	if header.Bits != ActiveNetParams.StakeTargetBits {
+		return fmt.Errorf("%w: got %08x, want %08x", ErrBadDifficulty, header.Bits, ActiveNetParams.StakeTargetBits)
+	}
+	if len(header.StakeTxID) == 0 {
+		return fmt.Errorf("%w: no staked output", ErrBadStake)
+	}
+	if !inMoneyRange(header.StakeValue) || header.StakeHeight < 0 {
+		return fmt.Errorf("%w: value %d at height %d", ErrBadStake, header.StakeValue, header.StakeHeight)
+	}
+
+	weight := stakeWeight(&UTXOEntry{Value: header.StakeValue, Height: header.StakeHeight}, header.Height)
+	if weight.Sign() == 0 {
+		return fmt.Errorf("%w: %x:%d is too young", ErrBadStake, header.StakeTxID, header.StakeOut)
+	}
+
+	if !checkStakeKernel(header, weight) {
+		return ErrBadStakeKernel
+	}
+
+	owner, err := ParseSignerPubKey(header.StakePubKey)
+	if err != nil || !verifyHeaderSignature(owner, header) {
+		return ErrBadSeal
+	}
+
+	return nil
+}
+
+// VerifyUTXO checks that the staked output is unspent, is the one the
+// header describes and is spent by the block.
+func (engine *PoSEngine) VerifyUTXO(txn StoreTxn, block *Block) error {
+	header := &block.BlockHeader
+	if !isStakeHeight(header.Height) {
+		return nil
+	}
+
+	entry, err := txn.GetUTXOEntry(header.StakeTxID, header.StakeOut)
+	if err != nil {
+		return fmt.Errorf("%w: %x:%d is not unspent", ErrBadStake, header.StakeTxID, header.StakeOut)
+	}
+
+	if entry.Value != header.StakeValue || entry.Height != header.StakeHeight || entry.PubKey != header.StakePubKey {
+		return fmt.Errorf("%w: %x:%d does not match the header", ErrBadStake, header.StakeTxID, header.StakeOut)
+	}
+
+	if !spendsStake(block) {
+		return fmt.Errorf("%w: %x:%d is not spent by the block", ErrBadStake, header.StakeTxID, header.StakeOut)
+	}
+
+	return nil
+}
+
+func (engine *PoSEngine) SelectFork(tipWork, candidateWork *big.Int) bool {
+	return candidateWork.Cmp(tipWork) > 0
+}
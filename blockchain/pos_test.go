@@ -0,0 +1,259 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newPoSTestChain returns a pos chain kept in memory whose proof-of-work
+// blocks pay key, which is loaded as the signer key. The returned function
+// puts back the regtest network.
+func newPoSTestChain(t *testing.T, key *ecdsa.PrivateKey) (*BlockChain, func()) {
+	t.Helper()
+
+	if err := SelectNetwork(PoSNetParams.Name); err != nil {
+		t.Fatal(err)
+	}
+	SignerKey = key
+	reset := func() {
+		SignerKey = nil
+		SelectNetwork(RegTestParams.Name)
+	}
+
+	chain, err := CreateBlockChain(NewMemoryStore())
+	if err != nil {
+		reset()
+		t.Fatal(err)
+	}
+	for i := 0; i < ActiveNetParams.LastPoWHeight; i++ {
+		mineTestBlock(t, chain, SignerPubKey(key))
+	}
+
+	return chain, reset
+}
+
+// forgeStakeBlock builds a block on parent that stakes claim, the output
+// txID:out as the header describes it, and signs it with key. It moves the
+// timestamp until the kernel meets the target.
+func forgeStakeBlock(t *testing.T, chain *BlockChain, parent []byte, txID []byte, out int, claim UTXOEntry, key *ecdsa.PrivateKey) *Block {
+	t.Helper()
+
+	prev, err := chain.GetHeader(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtp, err := chain.MedianTimePast(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:     BlockVersion,
+			PrevHash:    parent,
+			Timestamp:   mtp + 1,
+			Bits:        ActiveNetParams.StakeTargetBits,
+			Height:      prev.Height + 1,
+			StakeTxID:   txID,
+			StakeOut:    out,
+			StakeValue:  claim.Value,
+			StakeHeight: claim.Height,
+			StakePubKey: claim.PubKey,
+		},
+	}
+	block.Transactions = []*Transaction{
+		CoinbaseTx(SignerPubKey(key), "", ActiveNetParams.BlockReward),
+		coinstakeTx(&block.BlockHeader),
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	weight := stakeWeight(&claim, block.Height)
+	for !checkStakeKernel(&block.BlockHeader, weight) {
+		block.Timestamp++
+	}
+	if err := signHeader(key, &block.BlockHeader); err != nil {
+		t.Fatal(err)
+	}
+	block.Hash = block.BlockHeader.Hash()
+
+	return block
+}
+
+func TestStakeSealChecks(t *testing.T) {
+	key, err := NewSignerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := NewSignerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain, reset := newPoSTestChain(t, key)
+	defer reset()
+
+	mineTestBlock(t, chain, SignerPubKey(key))
+	block := mineTestBlock(t, chain, SignerPubKey(key))
+	if !isStakeHeight(block.Height) || block.StakePubKey != SignerPubKey(key) {
+		t.Fatalf("block %d is not staked by the signer key", block.Height)
+	}
+
+	engine := chain.Engine.(*PoSEngine)
+	if err := engine.VerifySeal(&block.BlockHeader); err != nil {
+		t.Fatalf("VerifySeal of a staked block = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		forge  func(header *BlockHeader)
+		signer bool
+		want   error
+	}{
+		{"signed by another key", func(h *BlockHeader) { signHeader(mallory, h) }, false, ErrBadSeal},
+		{"names another key", func(h *BlockHeader) { h.StakePubKey = SignerPubKey(mallory) }, false, ErrBadSeal},
+		{"inflated value", func(h *BlockHeader) { h.StakeValue = MaxMoney }, true, ErrBadStake},
+		{"too young", func(h *BlockHeader) { h.StakeHeight = h.Height }, true, ErrBadStake},
+		{"no kernel", func(h *BlockHeader) {
+			h.StakeValue = 1
+			h.StakeHeight = h.Height - ActiveNetParams.StakeMinAge
+			for checkStakeKernel(h, stakeWeight(&UTXOEntry{Value: 1, Height: h.StakeHeight}, h.Height)) {
+				h.Timestamp++
+			}
+		}, true, ErrBadStakeKernel},
+	}
+
+	for _, test := range tests {
+		header := block.BlockHeader
+		test.forge(&header)
+		if test.signer {
+			if err := signHeader(key, &header); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := engine.VerifySeal(&header)
+		if err == nil {
+			forged := *block
+			forged.BlockHeader = header
+			err = chain.Store.View(func(txn StoreTxn) error {
+				return engine.VerifyUTXO(txn, &forged)
+			})
+		}
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestSideBranchStake(t *testing.T) {
+	key, err := NewSignerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := NewSignerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain, reset := newPoSTestChain(t, key)
+	defer reset()
+
+	tip := mineTestBlock(t, chain, SignerPubKey(key))
+	first, err := chain.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbTx := first.Transactions[0]
+	stake := UTXOEntry{Value: cbTx.Outputs[0].Value, PubKey: cbTx.Outputs[0].PubKey, Height: 1}
+
+	side := forgeStakeBlock(t, chain, tip.PrevHash, cbTx.ID, 0, stake, key)
+	if err := chain.ValidateBlock(side); err != nil {
+		t.Fatalf("ValidateBlock of an honest side block = %v", err)
+	}
+
+	forged := []struct {
+		name  string
+		txID  []byte
+		claim UTXOEntry
+		key   *ecdsa.PrivateKey
+	}{
+		{"made up output", []byte("no such tx"), UTXOEntry{Value: MaxMoney, PubKey: SignerPubKey(mallory), Height: 1}, mallory},
+		{"foreign output", cbTx.ID, UTXOEntry{Value: stake.Value, PubKey: SignerPubKey(mallory), Height: 1}, mallory},
+		{"inflated value", cbTx.ID, UTXOEntry{Value: MaxMoney, PubKey: stake.PubKey, Height: 1}, key},
+		{"wrong height", cbTx.ID, UTXOEntry{Value: stake.Value, PubKey: stake.PubKey, Height: 2}, key},
+	}
+
+	for _, test := range forged {
+		block := forgeStakeBlock(t, chain, tip.PrevHash, test.txID, 0, test.claim, test.key)
+		if err := chain.ValidateBlock(block); !errors.Is(err, ErrBadStake) {
+			t.Errorf("%s: ValidateBlock = %v, want %v", test.name, err, ErrBadStake)
+		}
+	}
+}
+
+func TestStakeIsSpent(t *testing.T) {
+	key, err := NewSignerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain, reset := newPoSTestChain(t, key)
+	defer reset()
+
+	// A stake block that leaves the output unspent is refused.
+	tip, err := chain.GetBlockByHeight(ActiveNetParams.LastPoWHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := chain.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbTx := first.Transactions[0]
+	claim := UTXOEntry{Value: cbTx.Outputs[0].Value, PubKey: cbTx.Outputs[0].PubKey, Height: 1}
+	unspent := forgeStakeBlock(t, chain, tip.Hash, cbTx.ID, 0, claim, key)
+	unspent.Transactions = unspent.Transactions[:1]
+	unspent.MerkleRoot = unspent.HashTransactions()
+	if err := signHeader(key, &unspent.BlockHeader); err != nil {
+		t.Fatal(err)
+	}
+	unspent.Hash = unspent.BlockHeader.Hash()
+	if err := chain.ValidateBlock(unspent); !errors.Is(err, ErrBadStake) {
+		t.Errorf("ValidateBlock of a block leaving its stake unspent = %v, want %v", err, ErrBadStake)
+	}
+
+	block := mineTestBlock(t, chain, SignerPubKey(key))
+	if !isStakeHeight(block.Height) {
+		t.Fatalf("block %d is not a stake block", block.Height)
+	}
+
+	coinstake := block.Transactions[1]
+	if !reflect.DeepEqual(coinstake, coinstakeTx(&block.BlockHeader)) {
+		t.Fatalf("second transaction %+v is not the coinstake", coinstake)
+	}
+
+	// The staked output is gone and its value is back with its owner as a
+	// new output, too young to stake again.
+	err = chain.Store.View(func(txn StoreTxn) error {
+		if _, err := txn.GetUTXOEntry(block.StakeTxID, block.StakeOut); err == nil {
+			t.Errorf("staked output %x:%d is still unspent", block.StakeTxID, block.StakeOut)
+		}
+
+		entry, err := txn.GetUTXOEntry(coinstake.ID, 0)
+		if err != nil {
+			return err
+		}
+		if entry.Value != block.StakeValue || entry.PubKey != block.StakePubKey || entry.Height != block.Height {
+			t.Errorf("coinstake output = %+v, want %d to %s at height %d", entry, block.StakeValue, block.StakePubKey, block.Height)
+		}
+		if weight := stakeWeight(entry, block.Height+1); weight.Sign() != 0 {
+			t.Errorf("coinstake output weighs %s right away", weight)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,71 +2,280 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const Difficulty = 18
+// PoWEngine is the SHA-256 proof of work consensus. The target follows
+// calcNextBits and the branch with the most cumulative work wins.
+type PoWEngine struct{}
+
+func (engine *PoWEngine) Prepare(txn StoreTxn, header *BlockHeader) error {
+	bits, err := calcNextBits(txn, header.PrevHash)
+	if err != nil {
+		return err
+	}
+	header.Bits = bits
+
+	return nil
+}
+
+func (engine *PoWEngine) Seal(ctx context.Context, block *Block) error {
+	return SolveBlock(ctx, block, MineThreads)
+}
+
+func (engine *PoWEngine) VerifyHeader(txn StoreTxn, header *BlockHeader) error {
+	bits, err := calcNextBits(txn, header.PrevHash)
+	if err != nil {
+		return err
+	}
+	if header.Bits != bits {
+		return fmt.Errorf("%w: got %08x, want %08x", ErrBadDifficulty, header.Bits, bits)
+	}
+
+	return engine.VerifySeal(header)
+}
+
+// VerifySeal checks the proof of work against the header's own bits.
+func (engine *PoWEngine) VerifySeal(header *BlockHeader) error {
+	if !NewProof(header).Validate() {
+		return ErrBadProofOfWork
+	}
+
+	return nil
+}
+
+func (engine *PoWEngine) SelectFork(tipWork, candidateWork *big.Int) bool {
+	return candidateWork.Cmp(tipWork) > 0
+}
+
+var ErrNonceSpaceExhausted = errors.New("no nonce meets the target")
+
+// MineThreads is the number of goroutines SolveBlock runs for the engine.
+var MineThreads = runtime.NumCPU()
+
+// ctxCheckInterval is how many nonces Run tries between checks of its
+// context.
+const ctxCheckInterval = 4096
+
+// ProgressInterval is how often SolveBlock reports its progress.
+var ProgressInterval = 10 * time.Second
+
+// OnMiningProgress, if set, is called by SolveBlock every ProgressInterval.
+var OnMiningProgress func(MiningProgress)
+
+// MiningProgress is how far a SolveBlock call has got.
+type MiningProgress struct {
+	Height  int
+	Hashes  uint64
+	Elapsed time.Duration
+}
+
+// HashRate is the number of hashes per second so far.
+func (p MiningProgress) HashRate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(p.Hashes) / p.Elapsed.Seconds()
+}
+
+var (
+	hashRateMu    sync.Mutex
+	localHashRate float64
+)
+
+// LocalHashRate returns the hash rate of the last progress report of this
+// process.
+func LocalHashRate() float64 {
+	hashRateMu.Lock()
+	defer hashRateMu.Unlock()
+
+	return localHashRate
+}
+
+func recordHashRate(p MiningProgress) {
+	hashRateMu.Lock()
+	localHashRate = p.HashRate()
+	hashRateMu.Unlock()
+}
+
+func reportProgress(p MiningProgress) {
+	recordHashRate(p)
+
+	if OnMiningProgress != nil {
+		OnMiningProgress(p)
+	}
+}
 
 type ProofOfWork struct {
-	Block  *Block
+	Header *BlockHeader
 	Target *big.Int
+	// Hashes, if set, is increased by the number of nonces Run tries.
+	Hashes *uint64
 }
 
-func NewProof(block *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
+func NewProof(header *BlockHeader) *ProofOfWork {
+	target := CompactToBig(header.Bits)
 
-	pow := &ProofOfWork{block, target}
+	pow := &ProofOfWork{Header: header, Target: target}
 
 	return pow
 }
 
-func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
-		},
-		[]byte{},
-	)
+func (pow *ProofOfWork) InitData(nonce uint32) []byte {
+	header := *pow.Header
+	header.Nonce = nonce
 
-	return data
+	return header.Bytes()
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
+// Run tries every 32-bit nonce of the header once. It returns
+// ErrNonceSpaceExhausted when none meets the target, so the caller can
+// change the extra-nonce or timestamp and call it again.
+func (pow *ProofOfWork) Run(ctx context.Context) (uint32, []byte, error) {
 	var intHash big.Int
-	var hash [32]byte
 
-	nonce := 0
+	header := *pow.Header
 
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
+	var tried, counted uint64
+	count := func() {
+		if pow.Hashes != nil {
+			atomic.AddUint64(pow.Hashes, tried-counted)
+		}
+		counted = tried
+	}
+	defer count()
 
-		fmt.Printf("\r%x", hash)
+	for nonce := uint64(0); nonce <= math.MaxUint32; nonce++ {
+		if nonce%ctxCheckInterval == 0 {
+			count()
+			if ctx.Err() != nil {
+				return 0, nil, ctx.Err()
+			}
+		}
+
+		tried++
+		header.Nonce = uint32(nonce)
+		hash := sha256.Sum256(header.Bytes())
 		intHash.SetBytes(hash[:])
 
 		if intHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
+			return header.Nonce, hash[:], nil
 		}
 	}
-	fmt.Println()
 
-	return nonce, hash[:]
+	return 0, nil, ErrNonceSpaceExhausted
+}
+
+// SolveBlock finds a proof of work for block with the given number of
+// workers and stores it in the block. Worker i searches extra-nonces i,
+// i+threads, i+2*threads and so on, so no two workers ever hash the same
+// header. Each extra-nonce gives the coinbase, and with it the merkle root,
+// a fresh 32-bit nonce space, and the timestamp is rolled forward to the
+// current time whenever a worker moves on. The search only ends when a
+// worker succeeds or ctx is done.
+func SolveBlock(ctx context.Context, block *Block, threads int) error {
+	if threads < 1 {
+		threads = 1
+	}
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return ErrBadCoinbase
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var wg sync.WaitGroup
+	var solved *Block
+	var hashes uint64
+
+	// The reporter must not touch block, which is written back below.
+	height := block.Height
+	start := time.Now()
+	progress := func() MiningProgress {
+		return MiningProgress{height, atomic.LoadUint64(&hashes), time.Since(start)}
+	}
+
+	ticker := time.NewTicker(ProgressInterval)
+	defer ticker.Stop()
+	reporterDone := make(chan struct{})
+	go func() {
+		defer close(reporterDone)
+		for {
+			select {
+			case <-ticker.C:
+				reportProgress(progress())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(extraNonce uint64) {
+			defer wg.Done()
+
+			for ; ctx.Err() == nil; extraNonce += uint64(threads) {
+				candidate := block.withExtraNonce(extraNonce)
+				if now := AdjustedTime(); now > candidate.Timestamp {
+					candidate.Timestamp = now
+				}
+
+				pow := NewProof(&candidate.BlockHeader)
+				pow.Hashes = &hashes
+
+				nonce, hash, err := pow.Run(ctx)
+				if err != nil {
+					continue
+				}
+
+				once.Do(func() {
+					candidate.Nonce = nonce
+					candidate.Hash = hash
+					solved = candidate
+					cancel()
+				})
+				return
+			}
+		}(uint64(i))
+	}
+
+	wg.Wait()
+	cancel()
+	<-reporterDone
+	recordHashRate(progress())
+
+	if solved == nil {
+		return ctx.Err()
+	}
+
+	block.BlockHeader = solved.BlockHeader
+	block.Hash = solved.Hash
+	block.Transactions = solved.Transactions
+
+	return nil
 }
 
 func (pow *ProofOfWork) Validate() bool {
 	var intHash big.Int
 
-	data := pow.InitData(pow.Block.Nonce)
+	if pow.Target.Sign() <= 0 || pow.Target.Cmp(ActiveNetParams.PowLimit) > 0 {
+		return false
+	}
+
+	data := pow.InitData(pow.Header.Nonce)
 	hash := sha256.Sum256(data)
 	intHash.SetBytes(hash[:])
 
@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// newSolveTestBlock returns an unsolved block at height whose target needs
+// about 2^zeroBits hashes.
+func newSolveTestBlock(height int, zeroBits uint) *Block {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:  BlockVersion,
+			PrevHash: ToHex(int64(height)),
+			Bits:     BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-zeroBits)),
+			Height:   height,
+		},
+		Transactions: []*Transaction{CoinbaseTx("bench", fmt.Sprintf("bench %d", height), 0)},
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	return block
+}
+
+func TestSolveBlockReportsProgress(t *testing.T) {
+	interval := ProgressInterval
+	ProgressInterval = time.Microsecond
+	defer func() { ProgressInterval = interval }()
+
+	reports := 0
+	OnMiningProgress = func(MiningProgress) { reports++ }
+	defer func() { OnMiningProgress = nil }()
+
+	for i := 0; i < 200; i++ {
+		block := newSolveTestBlock(i, 8)
+		if err := SolveBlock(context.Background(), block, 2); err != nil {
+			t.Fatal(err)
+		}
+		if new(big.Int).SetBytes(block.Hash).Cmp(CompactToBig(block.Bits)) >= 0 {
+			t.Fatalf("block %d is not solved", i)
+		}
+	}
+	if reports == 0 {
+		t.Error("no progress was reported")
+	}
+}
+
+// BenchmarkSolveBlock mines the same headers with 1, 2, 4 and so on up to
+// runtime.NumCPU() workers, so the runs show how SolveBlock scales.
+func BenchmarkSolveBlock(b *testing.B) {
+	maxThreads := runtime.NumCPU()
+
+	for threads := 1; ; threads *= 2 {
+		if threads > maxThreads {
+			threads = maxThreads
+		}
+
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := SolveBlock(context.Background(), newSolveTestBlock(i, 16), threads); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		if threads == maxThreads {
+			break
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+// SpentIndexName is the name of the spent-outpoint index.
+const SpentIndexName = "spentindex"
+
+var spentIndexPrefix = []byte("sp-")
+
+// ErrUnknownOutput is returned for an output that is neither unspent nor
+// spent in the active chain.
+var ErrUnknownOutput = errors.New("output is not in the active chain")
+
+// SpendingInfo says which input of which transaction spent an output.
+type SpendingInfo struct {
+	TxID      []byte
+	Input     int
+	BlockHash []byte
+	Height    int
+}
+
+// SpentIndex maps every output spent in the active chain to the input
+// spending it.
+type SpentIndex struct{}
+
+func init() {
+	registerIndex(SpentIndex{})
+}
+
+func (SpentIndex) Name() string {
+	return SpentIndexName
+}
+
+func (SpentIndex) ConnectBlock(txn StoreTxn, block *Block, undo *BlockUndo) error {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for i, in := range tx.Inputs {
+			info := SpendingInfo{tx.ID, i, block.Hash, block.Height}
+
+			var buffer bytes.Buffer
+			if err := gob.NewEncoder(&buffer).Encode(info); err != nil {
+				return err
+			}
+
+			if err := txn.Set(spentIndexKey(in.ID, in.Out), buffer.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (SpentIndex) DisconnectBlock(txn StoreTxn, block *Block, undo *BlockUndo) error {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, in := range tx.Inputs {
+			if err := txn.Delete(spentIndexKey(in.ID, in.Out)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func spentIndexKey(txID []byte, out int) []byte {
+	key := append(append([]byte{}, spentIndexPrefix...), txID...)
+	return append(key, ToHex(int64(out))...)
+}
+
+// FindSpender returns what spent output out of txID in the active chain,
+// or nil if the output is still unspent.
+func (chain *BlockChain) FindSpender(txID []byte, out int) (*SpendingInfo, error) {
+	if !chain.HasIndex(SpentIndexName) {
+		return nil, fmt.Errorf("%s is not enabled", SpentIndexName)
+	}
+
+	var info *SpendingInfo
+
+	err := chain.Store.View(func(txn StoreTxn) error {
+		data, err := txn.Get(spentIndexKey(txID, out))
+		if err == ErrNotFound {
+			if _, err := txn.GetUTXOEntry(txID, out); err != nil {
+				return fmt.Errorf("%w: %x:%d", ErrUnknownOutput, txID, out)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		info = &SpendingInfo{}
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(info)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
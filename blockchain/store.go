@@ -0,0 +1,192 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrNotFound    = errors.New("key not found")
+	errReadOnlyTxn = errors.New("write in a read-only transaction")
+)
+
+var tipKey = []byte("lh")
+
+// ChainStore is where the chain keeps its blocks, tip and UTXO set. All
+// access goes through transactions: View sees a consistent state and
+// Update commits every change it makes at once, or none if fn fails.
+type ChainStore interface {
+	View(fn func(txn StoreTxn) error) error
+	Update(fn func(txn StoreTxn) error) error
+	Close() error
+}
+
+// StoreTxn is a transaction of a ChainStore. Besides raw keys it reads and
+// writes the records the chain is made of. Missing records are reported as
+// ErrNotFound.
+type StoreTxn interface {
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	// Iterate calls fn with every key starting with prefix, in key order.
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+
+	GetBlock(hash []byte) (*Block, error)
+	GetHeader(hash []byte) (*BlockHeader, error)
+	// PutBlock stores the block under its hash and its header separately,
+	// so headers can be read without decoding the transactions.
+	PutBlock(block *Block) error
+
+	GetTip() ([]byte, error)
+	SetTip(hash []byte) error
+
+	// GetHashByHeight returns the hash of the active chain's block at
+	// height.
+	GetHashByHeight(height int) ([]byte, error)
+	SetHashByHeight(height int, hash []byte) error
+	DeleteHashByHeight(height int) error
+
+	GetChainWork(hash []byte) (*big.Int, error)
+	SetChainWork(hash []byte, work *big.Int) error
+
+	GetUTXOEntry(txID []byte, out int) (*UTXOEntry, error)
+	PutUTXOEntry(txID []byte, out int, entry UTXOEntry) error
+	DeleteUTXOEntry(txID []byte, out int) error
+	// ForEachUTXO calls fn with every entry of the UTXO set, in outpoint
+	// order.
+	ForEachUTXO(fn func(txID []byte, out int, entry UTXOEntry) error) error
+
+	GetUndo(hash []byte) (*BlockUndo, error)
+	PutUndo(hash []byte, undo BlockUndo) error
+}
+
+// kvTxn is the raw key/value part of StoreTxn, which is all a store has to
+// implement.
+type kvTxn interface {
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+}
+
+// chainTxn lays the chain records out on top of the keys of a store.
+type chainTxn struct {
+	kvTxn
+}
+
+func (txn chainTxn) GetBlock(hash []byte) (*Block, error) {
+	data, err := txn.Get(blockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return Deserialize(data), nil
+}
+
+func (txn chainTxn) GetHeader(hash []byte) (*BlockHeader, error) {
+	data, err := txn.Get(headerKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return DeserializeHeader(data), nil
+}
+
+func (txn chainTxn) PutBlock(block *Block) error {
+	if err := txn.Set(blockKey(block.Hash), block.Serialize()); err != nil {
+		return err
+	}
+
+	return txn.Set(headerKey(block.Hash), block.BlockHeader.Serialize())
+}
+
+func (txn chainTxn) GetTip() ([]byte, error) {
+	return txn.Get(tipKey)
+}
+
+func (txn chainTxn) SetTip(hash []byte) error {
+	return txn.Set(tipKey, hash)
+}
+
+func (txn chainTxn) GetHashByHeight(height int) ([]byte, error) {
+	return txn.Get(heightKey(height))
+}
+
+func (txn chainTxn) SetHashByHeight(height int, hash []byte) error {
+	return txn.Set(heightKey(height), hash)
+}
+
+func (txn chainTxn) DeleteHashByHeight(height int) error {
+	return txn.Delete(heightKey(height))
+}
+
+func (txn chainTxn) GetChainWork(hash []byte) (*big.Int, error) {
+	data, err := txn.Get(chainWorkKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (txn chainTxn) SetChainWork(hash []byte, work *big.Int) error {
+	return txn.Set(chainWorkKey(hash), work.Bytes())
+}
+
+func (txn chainTxn) GetUTXOEntry(txID []byte, out int) (*UTXOEntry, error) {
+	data, err := txn.Get(utxoKey(txID, out))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := DeserializeUTXOEntry(data)
+	return &entry, nil
+}
+
+func (txn chainTxn) PutUTXOEntry(txID []byte, out int, entry UTXOEntry) error {
+	return txn.Set(utxoKey(txID, out), entry.Serialize())
+}
+
+func (txn chainTxn) DeleteUTXOEntry(txID []byte, out int) error {
+	return txn.Delete(utxoKey(txID, out))
+}
+
+func (txn chainTxn) ForEachUTXO(fn func(txID []byte, out int, entry UTXOEntry) error) error {
+	return txn.Iterate(utxoPrefix, func(key, value []byte) error {
+		txID, out := parseUTXOKey(key)
+
+		return fn(txID, out, DeserializeUTXOEntry(value))
+	})
+}
+
+func (txn chainTxn) GetUndo(hash []byte) (*BlockUndo, error) {
+	data, err := txn.Get(undoKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	undo := DeserializeBlockUndo(data)
+	return &undo, nil
+}
+
+func (txn chainTxn) PutUndo(hash []byte, undo BlockUndo) error {
+	return txn.Set(undoKey(hash), undo.Serialize())
+}
+
+// blockKey prefixes the block hash like every other key, so that no block
+// can land under the prefix of another record and show up in its scans.
+func blockKey(hash []byte) []byte {
+	return append(append([]byte{}, blockPrefix...), hash...)
+}
+
+func headerKey(hash []byte) []byte {
+	return append(append([]byte{}, headerPrefix...), hash...)
+}
+
+func chainWorkKey(hash []byte) []byte {
+	return append(append([]byte{}, chainWorkPrefix...), hash...)
+}
+
+func heightKey(height int) []byte {
+	return append(append([]byte{}, heightPrefix...), ToHex(int64(height))...)
+}
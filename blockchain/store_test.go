@@ -0,0 +1,34 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func TestBlockKeysStayOutOfScans(t *testing.T) {
+	chain, _ := newTestChain(t, "alice")
+	if err := chain.EnableIndex(TxIndexName); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks whose hashes start like the keys the chain scans by prefix.
+	for _, prefix := range [][]byte{indexMarkerPrefix, utxoPrefix} {
+		hash := append(append([]byte{}, prefix...), make([]byte, 32-len(prefix))...)
+		err := chain.Store.Update(func(txn StoreTxn) error {
+			return txn.PutBlock(&Block{Hash: hash})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resumed, err := OpenBlockChain(chain.Store)
+	if err != nil {
+		t.Fatalf("OpenBlockChain = %v", err)
+	}
+	if !resumed.HasIndex(TxIndexName) {
+		t.Error("txindex is not kept after resuming")
+	}
+	if entries := utxoSnapshot(t, resumed); len(entries) != 2 {
+		t.Errorf("got %d unspent outputs, want 2", len(entries))
+	}
+}
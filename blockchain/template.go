@@ -0,0 +1,226 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+const (
+	// MaxBlockSize caps the size of a block as measured by Block.Size.
+	MaxBlockSize = 1000000
+	// MaxBlockSigOps caps the number of signature checks in a block.
+	MaxBlockSigOps = 20000
+	// coinbaseReserve is left free in a template for the coinbase.
+	coinbaseReserve = 1000
+)
+
+// BlockTemplate is everything needed to build the next block on top of
+// PrevHash except the coinbase and the seal.
+type BlockTemplate struct {
+	PrevHash      []byte
+	Height        int
+	Bits          uint32
+	MinTime       int64
+	Transactions  []*Transaction
+	Fees          int
+	CoinbaseValue int
+	Size          int
+	SigOps        int
+}
+
+// candidate is a pool transaction with the fee it pays.
+type candidate struct {
+	tx      *Transaction
+	fee     int
+	size    int
+	feeRate float64
+}
+
+// NewBlockTemplate picks transactions from pool for the next block. It
+// takes them greedily by fee rate as long as they stay within MaxBlockSize
+// and MaxBlockSigOps. Transactions that do not fit, are not final yet, or
+// conflict with one already picked are left out for the caller to keep.
+func (chain *BlockChain) NewBlockTemplate(pool []*Transaction) (*BlockTemplate, error) {
+	tmpl := &BlockTemplate{}
+
+	err := chain.Store.View(func(txn StoreTxn) error {
+		var err error
+		tmpl.PrevHash, err = txn.GetTip()
+		if err != nil {
+			return err
+		}
+
+		tip, err := txn.GetHeader(tmpl.PrevHash)
+		if err != nil {
+			return err
+		}
+		tmpl.Height = tip.Height + 1
+
+		mtp, err := medianTimePast(txn, tip)
+		if err != nil {
+			return err
+		}
+		tmpl.MinTime = mtp + 1
+
+		header := BlockHeader{PrevHash: tmpl.PrevHash, Height: tmpl.Height, Timestamp: tmpl.MinTime}
+		if err := chain.Engine.Prepare(txn, &header); err != nil {
+			return err
+		}
+		tmpl.Bits = header.Bits
+
+		poolOutputs := make(map[string]TxOutput)
+		for _, tx := range pool {
+			for outIdx, out := range tx.Outputs {
+				poolOutputs[fmt.Sprintf("%x:%d", tx.ID, outIdx)] = out
+			}
+		}
+
+		var candidates []*candidate
+		for _, tx := range pool {
+			if c := newCandidate(txn, tx, poolOutputs); c != nil {
+				candidates = append(candidates, c)
+			}
+		}
+
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].feeRate != candidates[j].feeRate {
+				return candidates[i].feeRate > candidates[j].feeRate
+			}
+			return bytes.Compare(candidates[i].tx.ID, candidates[j].tx.ID) < 0
+		})
+
+		tmpl.fill(txn, candidates, mtp)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl.CoinbaseValue = ActiveNetParams.BlockReward + tmpl.Fees
+
+	return tmpl, nil
+}
+
+// newCandidate works out the fee of tx, looking its inputs up in the UTXO
+// set or among the other pool transactions. It returns nil if an input
+// cannot be found, a value is out of range or the outputs exceed the inputs.
+func newCandidate(txn StoreTxn, tx *Transaction, poolOutputs map[string]TxOutput) *candidate {
+	if tx.CheckOutputValues() != nil {
+		return nil
+	}
+	inputValue := 0
+
+	for _, in := range tx.Inputs {
+		out, ok := poolOutputs[fmt.Sprintf("%x:%d", in.ID, in.Out)]
+		if !ok {
+			entry, err := txn.GetUTXOEntry(in.ID, in.Out)
+			if err != nil {
+				return nil
+			}
+			out = entry.Output()
+		}
+
+		inputValue += out.Value
+		if !inMoneyRange(out.Value) || !inMoneyRange(inputValue) {
+			return nil
+		}
+	}
+
+	fee := inputValue - tx.OutputValue()
+	if fee < 0 {
+		return nil
+	}
+
+	size := tx.Size()
+
+	return &candidate{tx, fee, size, float64(fee) / float64(size)}
+}
+
+// fill adds candidates in order, passing over the list again while
+// transactions that were waiting on a parent in the pool become includable.
+func (tmpl *BlockTemplate) fill(txn StoreTxn, candidates []*candidate, mtp int64) {
+	created := make(map[string]bool)
+	spent := make(map[string]bool)
+	done := make(map[*candidate]bool)
+
+	tmpl.Size = len(tmpl.header().Bytes()) + coinbaseReserve
+
+	for progress := true; progress; {
+		progress = false
+
+	Candidates:
+		for _, c := range candidates {
+			if done[c] {
+				continue
+			}
+
+			if !c.tx.IsFinal(tmpl.Height, mtp) {
+				done[c] = true
+				continue
+			}
+
+			for _, in := range c.tx.Inputs {
+				outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+				if spent[outpoint] {
+					done[c] = true
+					continue Candidates
+				}
+				if created[outpoint] {
+					continue
+				}
+				if _, err := txn.GetUTXOEntry(in.ID, in.Out); err != nil {
+					// Spends a pool transaction that is not in yet.
+					continue Candidates
+				}
+			}
+
+			sigOps := c.tx.SigOpCount()
+			if tmpl.Size+c.size > MaxBlockSize || tmpl.SigOps+sigOps > MaxBlockSigOps {
+				done[c] = true
+				continue
+			}
+
+			for _, in := range c.tx.Inputs {
+				spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+			}
+			for outIdx := range c.tx.Outputs {
+				created[fmt.Sprintf("%x:%d", c.tx.ID, outIdx)] = true
+			}
+
+			tmpl.Transactions = append(tmpl.Transactions, c.tx)
+			tmpl.Fees += c.fee
+			tmpl.Size += c.size
+			tmpl.SigOps += sigOps
+			done[c] = true
+			progress = true
+		}
+	}
+}
+
+func (tmpl *BlockTemplate) header() *BlockHeader {
+	return &BlockHeader{
+		Version:   BlockVersion,
+		PrevHash:  tmpl.PrevHash,
+		Timestamp: tmpl.MinTime,
+		Bits:      tmpl.Bits,
+		Height:    tmpl.Height,
+	}
+}
+
+// NewBlock assembles an unsealed block from the template with coinbase as
+// its first transaction. The timestamp is the adjusted time, or MinTime if
+// that is later.
+func (tmpl *BlockTemplate) NewBlock(coinbase *Transaction) *Block {
+	block := &Block{
+		BlockHeader:  *tmpl.header(),
+		Transactions: append([]*Transaction{coinbase}, tmpl.Transactions...),
+	}
+	if now := AdjustedTime(); now > block.Timestamp {
+		block.Timestamp = now
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	return block
+}
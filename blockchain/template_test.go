@@ -0,0 +1,41 @@
+package blockchain
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// TestTemplateWhileMining builds templates while blocks are being added.
+// Run it with -race to check the tip is only read safely.
+func TestTemplateWhileMining(t *testing.T) {
+	chain, _ := newTestChain(t, "alice")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			cbTx := CoinbaseTx("alice", "", ActiveNetParams.BlockReward)
+			if _, _, err := chain.MineBlock(context.Background(), []*Transaction{cbTx}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		tmpl, err := chain.NewBlockTemplate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !chain.HasBlock(tmpl.PrevHash) {
+			t.Fatalf("template builds on unknown block %x", tmpl.PrevHash)
+		}
+		if _, err := chain.NetworkHashRate(10); err != nil {
+			t.Fatal(err)
+		}
+		chain.GetBlockHashes()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,102 @@
+package blockchain
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MedianTimeBlocks is how many previous blocks median-time-past covers.
+	MedianTimeBlocks = 11
+	// MaxTimeOffset caps how far peers can pull the adjusted time.
+	MaxTimeOffset  = 70 * 60
+	maxTimeSamples = 200
+)
+
+// MaxTimeDrift is how many seconds a block timestamp may be ahead of the
+// node-adjusted time.
+var MaxTimeDrift int64 = 2 * 60 * 60
+
+var (
+	timeOffsets = make(map[string]int64)
+	timeOffset  int64
+	timeLock    sync.Mutex
+)
+
+// AddTimeSample records the clock of a peer as reported in its version
+// message. The adjusted time is shifted by the median offset of all peers.
+// Samples are keyed by the remote IP and only the first one per IP counts,
+// so a single host cannot outvote the others by reconnecting.
+func AddTimeSample(remoteIP string, peerTime int64) {
+	timeLock.Lock()
+	defer timeLock.Unlock()
+
+	if _, ok := timeOffsets[remoteIP]; ok || len(timeOffsets) >= maxTimeSamples {
+		return
+	}
+	timeOffsets[remoteIP] = peerTime - time.Now().Unix()
+
+	var offsets []int64
+	for _, offset := range timeOffsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	median := offsets[len(offsets)/2]
+	if median > MaxTimeOffset || median < -MaxTimeOffset {
+		median = 0
+	}
+	timeOffset = median
+}
+
+// AdjustedTime is the local clock corrected by the median peer offset.
+func AdjustedTime() int64 {
+	timeLock.Lock()
+	defer timeLock.Unlock()
+
+	return time.Now().Unix() + timeOffset
+}
+
+// MedianTimePast returns the median timestamp of the block blockHash and the
+// MedianTimeBlocks-1 blocks before it. A block built on top of blockHash must
+// be stamped later than that.
+func (chain *BlockChain) MedianTimePast(blockHash []byte) (int64, error) {
+	var mtp int64
+
+	err := chain.Store.View(func(txn StoreTxn) error {
+		header, err := txn.GetHeader(blockHash)
+		if err != nil {
+			return err
+		}
+
+		mtp, err = medianTimePast(txn, header)
+		return err
+	})
+
+	return mtp, err
+}
+
+// medianTimePast returns the median timestamp of header and the blocks
+// before it, up to MedianTimeBlocks of them.
+func medianTimePast(txn StoreTxn, header *BlockHeader) (int64, error) {
+	var timestamps []int64
+
+	for i := 0; i < MedianTimeBlocks; i++ {
+		timestamps = append(timestamps, header.Timestamp)
+
+		if len(header.PrevHash) == 0 {
+			break
+		}
+
+		prev, err := txn.GetHeader(header.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+		header = prev
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2], nil
+}
@@ -0,0 +1,42 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimeSamplePerIP(t *testing.T) {
+	timeOffsets = make(map[string]int64)
+	defer func() {
+		timeOffsets = make(map[string]int64)
+		timeOffset = 0
+	}()
+
+	now := time.Now().Unix()
+	AddTimeSample("10.0.0.1", now+60)
+	AddTimeSample("10.0.0.2", now+60)
+
+	// Reconnecting from one host, under any port, adds nothing.
+	for i := 0; i < 10; i++ {
+		AddTimeSample("10.0.0.3", now-30*60)
+		AddTimeSample("10.0.0.1", now-30*60)
+	}
+
+	if len(timeOffsets) != 3 {
+		t.Fatalf("got %d samples, want 3", len(timeOffsets))
+	}
+	if offset := timeOffsets["10.0.0.1"]; offset < 59 || offset > 60 {
+		t.Errorf("first sample of 10.0.0.1 was replaced: offset %d", offset)
+	}
+	if offset := AdjustedTime() - time.Now().Unix(); offset < 59 || offset > 61 {
+		t.Errorf("adjusted time is off by %d, want 60", offset)
+	}
+
+	for i := 0; i < maxTimeSamples; i++ {
+		AddTimeSample(fmt.Sprintf("10.1.%d.%d", i/256, i%256), now)
+	}
+	if len(timeOffsets) != maxTimeSamples {
+		t.Errorf("got %d samples, want %d", len(timeOffsets), maxTimeSamples)
+	}
+}
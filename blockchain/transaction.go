@@ -10,23 +10,54 @@ import (
 	"log"
 )
 
+const (
+	// LockTimeThreshold separates lock times given as a block height (below)
+	// from lock times given as a unix timestamp (at or above).
+	LockTimeThreshold = 500000000
+)
+
 type Transaction struct {
-	ID      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime int64
 }
 
+// Hash hashes the fields of tx in a fixed layout. Gob output cannot be used
+// here because its type ids depend on what else the process has encoded.
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
-	txCopy := *tx
-	txCopy.ID = []byte{}
-
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(tx.hashData())
 
 	return hash[:]
 }
 
+func (tx *Transaction) hashData() []byte {
+	var data bytes.Buffer
+
+	data.Write(ToHex(int64(len(tx.Inputs))))
+	for _, in := range tx.Inputs {
+		data.Write(ToHex(int64(len(in.ID))))
+		data.Write(in.ID)
+		data.Write(ToHex(int64(in.Out)))
+		data.Write(ToHex(int64(len(in.Sig))))
+		data.WriteString(in.Sig)
+		data.Write(ToHex(int64(in.ExtraNonce)))
+	}
+
+	data.Write(ToHex(int64(len(tx.Outputs))))
+	for _, out := range tx.Outputs {
+		data.Write(ToHex(int64(out.Value)))
+		data.Write(ToHex(int64(len(out.PubKey))))
+		data.WriteString(out.PubKey)
+	}
+
+	data.Write(ToHex(tx.LockTime))
+
+	return data.Bytes()
+}
+
 func (tx Transaction) Serialize() []byte {
 	var encoded bytes.Buffer
 
@@ -40,15 +71,22 @@ func (tx Transaction) Serialize() []byte {
 }
 
 func DeserializeTransaction(data []byte) Transaction {
+	transaction, err := DecodeTransaction(data)
+	Handle(err)
+	return transaction
+}
+
+// DecodeTransaction is DeserializeTransaction for data from the network. It
+// returns an error instead of panicking when data is not a transaction.
+func DecodeTransaction(data []byte) (Transaction, error) {
 	var transaction Transaction
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&transaction)
-	Handle(err)
-	return transaction
+	return transaction, err
 }
 
-func CoinbaseTx(to, data string) *Transaction {
+func CoinbaseTx(to, data string, value int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -57,22 +95,24 @@ func CoinbaseTx(to, data string) *Transaction {
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TxInput{[]byte{}, -1, data}
-	txout := TxOutput{33, to}
+	txin := TxInput{ID: []byte{}, Out: -1, Sig: data}
+	txout := TxOutput{value, to}
 
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{txout}}
+	tx := Transaction{Inputs: []TxInput{txin}, Outputs: []TxOutput{txout}}
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
-func NewTransaction(from, to string, amount int, UTXO *UTXOSet) *Transaction {
+// NewTransaction sends amount from one address to another and leaves fee
+// for the miner that includes it.
+func NewTransaction(from, to string, amount, fee int, UTXO *UTXOSet) *Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
-	acc, validOutputs := UTXO.FindSpendableOutputs(from, amount)
+	acc, validOutputs := UTXO.FindSpendableOutputs(from, amount+fee)
 
-	if acc < amount {
+	if acc < amount+fee {
 		log.Panic("Error: Not enough funds")
 	}
 
@@ -81,18 +121,18 @@ func NewTransaction(from, to string, amount int, UTXO *UTXOSet) *Transaction {
 		Handle(err)
 
 		for _, out := range outs {
-			input := TxInput{txID, out, from}
+			input := TxInput{ID: txID, Out: out, Sig: from}
 			inputs = append(inputs, input)
 		}
 	}
 
 	outputs = append(outputs, TxOutput{amount, to})
 
-	if acc > amount {
-		outputs = append(outputs, TxOutput{acc - amount, from})
+	if acc > amount+fee {
+		outputs = append(outputs, TxOutput{acc - amount - fee, from})
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{Inputs: inputs, Outputs: outputs}
 	tx.ID = tx.Hash()
 
 	return &tx
@@ -142,6 +182,69 @@ func CheckTransactions(transactions []*Transaction, UTXO *UTXOSet) bool {
 	return true
 }
 
+// IsFinal reports whether tx may be included in a block at height whose
+// parent has the given median-time-past. Time locks are compared against
+// median-time-past rather than the block's own timestamp, which the miner
+// chooses.
+func (tx *Transaction) IsFinal(height int, medianTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	if tx.LockTime < LockTimeThreshold {
+		return tx.LockTime < int64(height)
+	}
+
+	return tx.LockTime < medianTime
+}
+
+// Size is the length of the fixed encoding of tx that block size limits are
+// measured in.
+func (tx *Transaction) Size() int {
+	return len(tx.ID) + len(tx.hashData())
+}
+
+// SigOpCount is the number of signature checks needed to spend the inputs.
+func (tx *Transaction) SigOpCount() int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	return len(tx.Inputs)
+}
+
+// CheckOutputValues makes sure every output and their total lie within
+// MaxMoney. OutputValue cannot overflow once it passes.
+func (tx *Transaction) CheckOutputValues() error {
+	total := 0
+	for _, out := range tx.Outputs {
+		if !inMoneyRange(out.Value) {
+			return ErrBadOutputValue
+		}
+		total += out.Value
+		if !inMoneyRange(total) {
+			return ErrValueOutOfRange
+		}
+	}
+
+	return nil
+}
+
+func inMoneyRange(value int) bool {
+	return value >= 0 && value <= MaxMoney
+}
+
+// OutputValue sums the outputs. Call CheckOutputValues first on
+// transactions that have not been validated.
+func (tx *Transaction) OutputValue() int {
+	total := 0
+	for _, out := range tx.Outputs {
+		total += out.Value
+	}
+
+	return total
+}
+
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
@@ -1,14 +1,12 @@
 package blockchain
 
-import (
-	"bytes"
-	"encoding/gob"
-)
-
 type TxInput struct {
 	ID  []byte
 	Out int
 	Sig string
+	// ExtraNonce is only set on the coinbase input. Miners change it to get
+	// a new merkle root once the header nonces run out.
+	ExtraNonce uint64
 }
 
 type TxOutput struct {
@@ -16,10 +14,6 @@ type TxOutput struct {
 	PubKey string
 }
 
-type TxOutputs struct {
-	Outputs []TxOutput
-}
-
 func (input *TxInput) CanUnlock(data string) bool {
 	return input.Sig == data
 }
@@ -27,23 +21,3 @@ func (input *TxInput) CanUnlock(data string) bool {
 func (output *TxOutput) CanBeUnlocked(data string) bool {
 	return output.PubKey == data
 }
-
-func (outs TxOutputs) Serialize() []byte {
-	var buffer bytes.Buffer
-
-	encode := gob.NewEncoder(&buffer)
-	err := encode.Encode(outs)
-	Handle(err)
-
-	return buffer.Bytes()
-}
-
-func DeserializeOutputs(data []byte) TxOutputs {
-	var outputs TxOutputs
-
-	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&outputs)
-	Handle(err)
-
-	return outputs
-}
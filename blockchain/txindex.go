@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// TxIndexName is the name of the transaction index.
+const TxIndexName = "txindex"
+
+var txIndexPrefix = []byte("tx-")
+
+// TxLocation is where a transaction sits in the active chain.
+type TxLocation struct {
+	BlockHash []byte
+	Height    int
+	Position  int
+}
+
+// TxIndex maps the ID of every transaction in the active chain to its
+// block and position.
+type TxIndex struct{}
+
+func init() {
+	registerIndex(TxIndex{})
+}
+
+func (TxIndex) Name() string {
+	return TxIndexName
+}
+
+func (TxIndex) ConnectBlock(txn StoreTxn, block *Block, undo *BlockUndo) error {
+	for i, tx := range block.Transactions {
+		loc := TxLocation{block.Hash, block.Height, i}
+
+		var buffer bytes.Buffer
+		if err := gob.NewEncoder(&buffer).Encode(loc); err != nil {
+			return err
+		}
+
+		if err := txn.Set(txIndexKey(tx.ID), buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (TxIndex) DisconnectBlock(txn StoreTxn, block *Block, undo *BlockUndo) error {
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(txIndexKey(tx.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func txIndexKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+// FindTransaction looks a transaction of the active chain up in the
+// transaction index.
+func (chain *BlockChain) FindTransaction(txID []byte) (*Transaction, *TxLocation, error) {
+	if !chain.HasIndex(TxIndexName) {
+		return nil, nil, fmt.Errorf("%s is not enabled", TxIndexName)
+	}
+
+	var tx *Transaction
+	var loc TxLocation
+
+	err := chain.Store.View(func(txn StoreTxn) error {
+		data, err := txn.Get(txIndexKey(txID))
+		if err != nil {
+			return fmt.Errorf("transaction %x is not found", txID)
+		}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&loc); err != nil {
+			return err
+		}
+
+		block, err := txn.GetBlock(loc.BlockHash)
+		if err != nil {
+			return err
+		}
+		tx = block.Transactions[loc.Position]
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tx, &loc, nil
+}
@@ -2,14 +2,16 @@ package blockchain
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/hex"
+	"fmt"
 	"log"
-
-	"github.com/dgraph-io/badger"
 )
 
 var (
 	utxoPrefix   = []byte("utxo-")
+	undoPrefix   = []byte("undo-")
 	prefixLength = len(utxoPrefix)
 )
 
@@ -17,34 +19,88 @@ type UTXOSet struct {
 	Blockchain *BlockChain
 }
 
+// UTXOEntry is an unspent output together with the height and kind of the
+// transaction that created it. Entries are keyed by outpoint, so an output
+// keeps its index for as long as it stays unspent.
+type UTXOEntry struct {
+	Value    int
+	PubKey   string
+	Height   int
+	Coinbase bool
+}
+
+// SpentOutput is an entry removed from the set when a block was connected.
+type SpentOutput struct {
+	TxID []byte
+	Out  int
+	UTXOEntry
+}
+
+// BlockUndo holds everything a block spent, in the order it was spent, so
+// the block can be disconnected without replaying the chain.
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+func (entry UTXOEntry) Output() TxOutput {
+	return TxOutput{entry.Value, entry.PubKey}
+}
+
+func (entry UTXOEntry) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	encode := gob.NewEncoder(&buffer)
+	err := encode.Encode(entry)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+func DeserializeUTXOEntry(data []byte) UTXOEntry {
+	var entry UTXOEntry
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	err := decode.Decode(&entry)
+	Handle(err)
+
+	return entry
+}
+
+func (undo BlockUndo) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	encode := gob.NewEncoder(&buffer)
+	err := encode.Encode(undo)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+func DeserializeBlockUndo(data []byte) BlockUndo {
+	var undo BlockUndo
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	err := decode.Decode(&undo)
+	Handle(err)
+
+	return undo
+}
+
 func (u UTXOSet) FindSpendableOutputs(address string, amount int) (int, map[string][]int) {
 	unspentOuts := make(map[string][]int)
 	accumulated := 0
-	db := u.Blockchain.Database
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
 
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			v, err := item.Value()
-			Handle(err)
-			k = bytes.TrimPrefix(k, utxoPrefix)
+	err := u.Blockchain.Store.View(func(txn StoreTxn) error {
+		return txn.ForEachUTXO(func(k []byte, outIdx int, entry UTXOEntry) error {
 			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
+			out := entry.Output()
 
-			for outIdx, out := range outs.Outputs {
-				if out.CanBeUnlocked(address) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
-				}
+			if out.CanBeUnlocked(address) && accumulated < amount {
+				accumulated += out.Value
+				unspentOuts[txID] = append(unspentOuts[txID], outIdx)
 			}
-		}
-		return nil
+			return nil
+		})
 	})
 	Handle(err)
 
@@ -54,48 +110,36 @@ func (u UTXOSet) FindSpendableOutputs(address string, amount int) (int, map[stri
 func (u UTXOSet) FindUTXO(address string) []TxOutput {
 	var UTXOs []TxOutput
 
-	db := u.Blockchain.Database
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			v, err := item.Value()
-			Handle(err)
-			outs := DeserializeOutputs(v)
+	err := u.Blockchain.Store.View(func(txn StoreTxn) error {
+		return txn.ForEachUTXO(func(_ []byte, _ int, entry UTXOEntry) error {
+			out := entry.Output()
 
-			for _, out := range outs.Outputs {
-				if out.CanBeUnlocked(address) {
-					UTXOs = append(UTXOs, out)
-				}
+			if out.CanBeUnlocked(address) {
+				UTXOs = append(UTXOs, out)
 			}
-		}
-
-		return nil
+			return nil
+		})
 	})
 	Handle(err)
 
 	return UTXOs
 }
 
+// CountTransactions returns the number of transactions that still have at
+// least one unspent output.
 func (u UTXOSet) CountTransactions() int {
-	db := u.Blockchain.Database
 	counter := 0
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			counter++
-		}
+	err := u.Blockchain.Store.View(func(txn StoreTxn) error {
+		var lastTxID []byte
 
-		return nil
+		return txn.ForEachUTXO(func(txID []byte, _ int, _ UTXOEntry) error {
+			if !bytes.Equal(txID, lastTxID) {
+				counter++
+				lastTxID = txID
+			}
+			return nil
+		})
 	})
 
 	Handle(err)
@@ -103,122 +147,168 @@ func (u UTXOSet) CountTransactions() int {
 	return counter
 }
 
+// Reindex rebuilds the set by connecting every block of the active chain
+// again, starting from genesis.
 func (u UTXOSet) Reindex() {
-	db := u.Blockchain.Database
+	store := u.Blockchain.Store
 
 	u.DeleteByPrefix(utxoPrefix)
 
-	UTXO := u.Blockchain.FindUTXO()
+	hashes := u.Blockchain.GetBlockHashes()
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
+	for i := len(hashes) - 1; i >= 0; i-- {
+		err := store.Update(func(txn StoreTxn) error {
+			block, err := txn.GetBlock(hashes[i])
 			if err != nil {
 				return err
 			}
-			key = append(utxoPrefix, key...)
 
-			err = txn.Set(key, outs.Serialize())
-			Handle(err)
-		}
+			return u.update(txn, block)
+		})
+		Handle(err)
+	}
+}
+
+func (u *UTXOSet) Update(block *Block) {
+	err := u.Blockchain.Store.Update(func(txn StoreTxn) error {
+		return u.update(txn, block)
+	})
+	Handle(err)
+}
 
-		return nil
+// Disconnect reverses Update for the current tip block, using the undo
+// record written when the block was connected.
+func (u *UTXOSet) Disconnect(block *Block) {
+	err := u.Blockchain.Store.Update(func(txn StoreTxn) error {
+		return u.disconnect(txn, block)
 	})
 	Handle(err)
 }
 
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
-
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					Handle(err)
-					v, err := item.Value()
-					Handle(err)
-
-					outs := DeserializeOutputs(v)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
+// update spends the inputs and adds the outputs of block inside txn, so the
+// caller can commit it together with the tip change. Every spent entry is
+// saved in the block's undo record.
+func (u *UTXOSet) update(txn StoreTxn, block *Block) error {
+	undo := BlockUndo{}
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				entry, err := txn.GetUTXOEntry(in.ID, in.Out)
+				if err != nil {
+					return fmt.Errorf("input %x:%d of %x is not unspent", in.ID, in.Out, tx.ID)
+				}
+
+				undo.Spent = append(undo.Spent, SpentOutput{in.ID, in.Out, *entry})
+
+				if err := txn.DeleteUTXOEntry(in.ID, in.Out); err != nil {
+					return err
 				}
 			}
+		}
 
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+		for outIdx, out := range tx.Outputs {
+			entry := UTXOEntry{out.Value, out.PubKey, block.Height, tx.IsCoinbase()}
+
+			if err := txn.PutUTXOEntry(tx.ID, outIdx, entry); err != nil {
+				return err
 			}
+		}
+	}
+
+	return txn.PutUndo(block.Hash, undo)
+}
+
+// disconnect walks the block's transactions backwards, removing the outputs
+// each one created and putting back the entries it spent.
+func (u *UTXOSet) disconnect(txn StoreTxn, block *Block) error {
+	undo, err := txn.GetUndo(block.Hash)
+	if err != nil {
+		return fmt.Errorf("undo data of block %x is not found", block.Hash)
+	}
+	spent := undo.Spent
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
 
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
+		for outIdx := range tx.Outputs {
+			if err := txn.DeleteUTXOEntry(tx.ID, outIdx); err != nil {
+				return err
 			}
 		}
 
-		return nil
-	})
-	Handle(err)
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for j := len(tx.Inputs) - 1; j >= 0; j-- {
+			if len(spent) == 0 {
+				return fmt.Errorf("undo data of block %x is incomplete", block.Hash)
+			}
+			out := spent[len(spent)-1]
+			spent = spent[:len(spent)-1]
+
+			if err := txn.PutUTXOEntry(out.TxID, out.Out, out.UTXOEntry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func utxoKey(txID []byte, out int) []byte {
+	key := append(append([]byte{}, utxoPrefix...), txID...)
+
+	return append(key, ToHex(int64(out))...)
+}
+
+func parseUTXOKey(key []byte) ([]byte, int) {
+	key = bytes.TrimPrefix(key, utxoPrefix)
+	split := len(key) - 8
+
+	return key[:split], int(binary.BigEndian.Uint64(key[split:]))
 }
 
+func undoKey(hash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), hash...)
+}
+
+// DeleteByPrefix removes every key starting with prefix, committing the
+// deletes in batches.
 func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
+	store := u.Blockchain.Store
+
 	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		return store.Update(func(txn StoreTxn) error {
 			for _, key := range keysForDelete {
 				if err := txn.Delete(key); err != nil {
 					return err
 				}
 			}
 			return nil
-		}); err != nil {
-			return err
-		}
-		return nil
+		})
 	}
 
 	collectSize := 100000
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		keysForDelete := make([][]byte, 0, collectSize)
-		keysCollected := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().KeyCopy(nil)
+	var keysForDelete [][]byte
+
+	err := store.View(func(txn StoreTxn) error {
+		return txn.Iterate(prefix, func(key, _ []byte) error {
 			keysForDelete = append(keysForDelete, key)
-			keysCollected++
-			if keysCollected == collectSize {
+			if len(keysForDelete) == collectSize {
 				if err := deleteKeys(keysForDelete); err != nil {
-					log.Panic(err)
+					return err
 				}
-				keysForDelete = make([][]byte, 0, collectSize)
-				keysCollected = 0
+				keysForDelete = nil
 			}
-		}
-		if keysCollected > 0 {
-			if err := deleteKeys(keysForDelete); err != nil {
-				log.Panic(err)
-			}
-		}
-		return nil
+			return nil
+		})
 	})
+	if err == nil && len(keysForDelete) > 0 {
+		err = deleteKeys(keysForDelete)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
 }
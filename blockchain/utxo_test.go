@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func utxoSnapshot(t *testing.T, chain *BlockChain) map[string]UTXOEntry {
+	t.Helper()
+
+	entries := make(map[string]UTXOEntry)
+	err := chain.Store.View(func(txn StoreTxn) error {
+		return txn.ForEachUTXO(func(txID []byte, out int, entry UTXOEntry) error {
+			entries[fmt.Sprintf("%x:%d", txID, out)] = entry
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entries
+}
+
+// checkReindex rebuilds the UTXO set from the blocks and compares it with
+// the one kept up to date block by block.
+func checkReindex(t *testing.T, chain *BlockChain) {
+	t.Helper()
+
+	kept := utxoSnapshot(t, chain)
+	UTXOSet{chain}.Reindex()
+	if rebuilt := utxoSnapshot(t, chain); !reflect.DeepEqual(kept, rebuilt) {
+		t.Errorf("UTXO set = %v, reindexed = %v", kept, rebuilt)
+	}
+}
+
+func TestDisconnectRestoresUTXOSet(t *testing.T) {
+	chain, _ := newTestChain(t, "alice")
+	before := utxoSnapshot(t, chain)
+
+	tx := NewTransaction("alice", "bob", 5, 1, &UTXOSet{chain})
+	block := mineTestBlock(t, chain, "alice", tx)
+	checkReindex(t, chain)
+
+	(&UTXOSet{chain}).Disconnect(block)
+	if after := utxoSnapshot(t, chain); !reflect.DeepEqual(before, after) {
+		t.Errorf("UTXO set after Disconnect = %v, want %v", after, before)
+	}
+}
+
+func TestReorgMatchesReindex(t *testing.T) {
+	chain, _ := newTestChain(t, "alice")
+	tx := NewTransaction("alice", "bob", 5, 1, &UTXOSet{chain})
+	mineTestBlock(t, chain, "alice", tx)
+
+	fork, err := CreateBlockChain(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var branch []*Block
+	for i := 0; i < 3; i++ {
+		branch = append(branch, mineTestBlock(t, fork, "carol"))
+	}
+
+	var update *ChainUpdate
+	for _, block := range branch {
+		u, err := chain.AddBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != nil {
+			update = u
+		}
+	}
+
+	if update == nil || len(update.Disconnected) != 2 {
+		t.Fatalf("reorg update = %+v, want two blocks disconnected", update)
+	}
+	if string(chain.LastHash) != string(branch[len(branch)-1].Hash) {
+		t.Fatalf("tip = %x, want %x", chain.LastHash, branch[len(branch)-1].Hash)
+	}
+	if balance := len(UTXOSet{chain}.FindUTXO("bob")); balance != 0 {
+		t.Errorf("bob keeps %d outputs of the disconnected branch", balance)
+	}
+
+	checkReindex(t, chain)
+}
@@ -0,0 +1,328 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// MaxMoney caps any single output value and any sum of values, so totals
+// can be added up without overflowing.
+const MaxMoney = 21000000
+
+// Reasons a block or transaction is rejected. ValidateBlock wraps them with
+// details, use errors.Is to tell them apart.
+var (
+	ErrBadBlockHash      = errors.New("block hash does not match its header")
+	ErrUnknownParent     = errors.New("parent block is not known")
+	ErrBadHeight         = errors.New("height is not the parent height plus one")
+	ErrBadDifficulty     = errors.New("unexpected difficulty target")
+	ErrBadProofOfWork    = errors.New("proof of work does not meet the target")
+	ErrTimeTooOld        = errors.New("timestamp is not after median-time-past")
+	ErrTimeTooNew        = errors.New("timestamp is too far in the future")
+	ErrBadMerkleRoot     = errors.New("merkle root does not match the transactions")
+	ErrNoTransactions    = errors.New("block has no transactions")
+	ErrBlockTooLarge     = errors.New("block exceeds the size limit")
+	ErrTooManySigOps     = errors.New("block exceeds the signature operation limit")
+	ErrBadCoinbase       = errors.New("first transaction must be the only coinbase")
+	ErrBadTxID           = errors.New("transaction id does not match its contents")
+	ErrDuplicateTx       = errors.New("transaction appears twice")
+	ErrOverwritesUTXO    = errors.New("transaction id already has unspent outputs")
+	ErrBadOutputValue    = errors.New("output value is negative or above MaxMoney")
+	ErrValueOutOfRange   = errors.New("total value is above MaxMoney")
+	ErrMissingInput      = errors.New("input is not an unspent output")
+	ErrDoubleSpend       = errors.New("output is spent twice")
+	ErrBadSignature      = errors.New("input does not unlock the output")
+	ErrInsufficientFunds = errors.New("outputs exceed inputs")
+	ErrBadCoinbaseValue  = errors.New("coinbase pays more than reward and fees")
+	ErrNonFinalTx        = errors.New("transaction is still time locked")
+)
+
+// ValidateBlock runs every check that can be made before block is stored.
+// Its transactions are checked against the UTXO set when the block extends
+// the current tip; blocks on a side branch have their transactions checked
+// by AddBlock once the branch is connected.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	if !bytes.Equal(block.Hash, block.BlockHeader.Hash()) {
+		return ErrBadBlockHash
+	}
+
+	if err := CheckBlockSanity(block); err != nil {
+		return err
+	}
+
+	return chain.Store.View(func(txn StoreTxn) error {
+		parent, err := txn.GetHeader(block.PrevHash)
+		if err != nil {
+			return ErrUnknownParent
+		}
+
+		if block.Height != parent.Height+1 {
+			return fmt.Errorf("%w: got %d, parent is at %d", ErrBadHeight, block.Height, parent.Height)
+		}
+
+		tipHash, err := txn.GetTip()
+		if err != nil {
+			return err
+		}
+		tip, err := txn.GetHeader(tipHash)
+		if err != nil {
+			return err
+		}
+		if err := checkCheckpoint(block.Height, block.Hash, tip.Height); err != nil {
+			return err
+		}
+
+		mtp, err := medianTimePast(txn, parent)
+		if err != nil {
+			return err
+		}
+		if block.Timestamp <= mtp {
+			return fmt.Errorf("%w: %d <= %d", ErrTimeTooOld, block.Timestamp, mtp)
+		}
+		if block.Timestamp > AdjustedTime()+MaxTimeDrift {
+			return fmt.Errorf("%w: %d", ErrTimeTooNew, block.Timestamp)
+		}
+
+		if err := chain.Engine.VerifyHeader(txn, &block.BlockHeader); err != nil {
+			return err
+		}
+
+		if bytes.Equal(block.PrevHash, tipHash) {
+			return chain.checkConnect(txn, block)
+		}
+
+		return nil
+	})
+}
+
+// CheckOrphan runs the checks that can be made on a block whose parent is
+// not known yet: its hash, CheckBlockSanity and the seal of the engine.
+func (chain *BlockChain) CheckOrphan(block *Block) error {
+	if !bytes.Equal(block.Hash, block.BlockHeader.Hash()) {
+		return ErrBadBlockHash
+	}
+
+	if err := CheckBlockSanity(block); err != nil {
+		return err
+	}
+
+	return chain.Engine.VerifySeal(&block.BlockHeader)
+}
+
+// CheckBlockSanity runs the checks that need nothing but the block itself.
+func CheckBlockSanity(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ErrNoTransactions
+	}
+
+	if size := block.Size(); size > MaxBlockSize {
+		return fmt.Errorf("%w: %d bytes", ErrBlockTooLarge, size)
+	}
+
+	if sigOps := block.SigOpCount(); sigOps > MaxBlockSigOps {
+		return fmt.Errorf("%w: %d", ErrTooManySigOps, sigOps)
+	}
+
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return ErrBadMerkleRoot
+	}
+
+	seen := make(map[string]bool)
+
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() != (i == 0) {
+			return ErrBadCoinbase
+		}
+
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return fmt.Errorf("%w: %x", ErrBadTxID, tx.ID)
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if seen[txID] {
+			return fmt.Errorf("%w: %s", ErrDuplicateTx, txID)
+		}
+		seen[txID] = true
+
+		if err := tx.CheckOutputValues(); err != nil {
+			return fmt.Errorf("%w: %s", err, txID)
+		}
+	}
+
+	return nil
+}
+
+// checkConnect runs the checks that need the UTXO set as of the parent of
+// block: those of the engine, if any, and checkBlockInputs.
+func (chain *BlockChain) checkConnect(txn StoreTxn, block *Block) error {
+	if verifier, ok := chain.Engine.(UTXOVerifier); ok {
+		if err := verifier.VerifyUTXO(txn, block); err != nil {
+			return err
+		}
+	}
+
+	return checkBlockInputs(txn, block)
+}
+
+// checkBlockInputs verifies that every input of block spends an output that
+// is unspent at that point, either in the UTXO set or created earlier in the
+// same block, that every transaction is final with values within MaxMoney,
+// that none reuses the id of a transaction with unspent outputs, and that
+// the coinbase claims no more than reward and fees. Unlock checks are
+// skipped below the assume-valid block.
+func checkBlockInputs(txn StoreTxn, block *Block) error {
+	created := make(map[string]TxOutput)
+	spent := make(map[string]bool)
+	fees := 0
+	skipUnlock := isAssumedValid(txn, block)
+
+	parent, err := txn.GetHeader(block.PrevHash)
+	if err != nil {
+		return ErrUnknownParent
+	}
+	mtp, err := medianTimePast(txn, parent)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		if !tx.IsFinal(block.Height, mtp) {
+			return fmt.Errorf("%w: %x", ErrNonFinalTx, tx.ID)
+		}
+		if err := tx.CheckOutputValues(); err != nil {
+			return fmt.Errorf("%w: %x", err, tx.ID)
+		}
+
+		// The outputs are keyed by txid, so a duplicate would overwrite
+		// them and disconnecting it would lose the originals.
+		for outIdx := range tx.Outputs {
+			if _, err := txn.GetUTXOEntry(tx.ID, outIdx); err == nil {
+				return fmt.Errorf("%w: %x", ErrOverwritesUTXO, tx.ID)
+			}
+		}
+
+		if !tx.IsCoinbase() {
+			inputValue := 0
+
+			for _, in := range tx.Inputs {
+				outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+				if spent[outpoint] {
+					return fmt.Errorf("%w: %s", ErrDoubleSpend, outpoint)
+				}
+				spent[outpoint] = true
+
+				out, ok := created[outpoint]
+				if !ok {
+					entry, err := txn.GetUTXOEntry(in.ID, in.Out)
+					if err != nil {
+						return fmt.Errorf("%w: %s", ErrMissingInput, outpoint)
+					}
+					out = entry.Output()
+				}
+
+				if !skipUnlock && !in.CanUnlock(out.PubKey) {
+					return fmt.Errorf("%w: %s", ErrBadSignature, outpoint)
+				}
+
+				inputValue += out.Value
+				if !inMoneyRange(inputValue) {
+					return fmt.Errorf("%w: inputs of %x", ErrValueOutOfRange, tx.ID)
+				}
+			}
+
+			outputValue := tx.OutputValue()
+			if outputValue > inputValue {
+				return fmt.Errorf("%w: %x", ErrInsufficientFunds, tx.ID)
+			}
+			fees += inputValue - outputValue
+			if !inMoneyRange(fees) {
+				return fmt.Errorf("%w: fees", ErrValueOutOfRange)
+			}
+		}
+
+		for outIdx, out := range tx.Outputs {
+			created[fmt.Sprintf("%x:%d", tx.ID, outIdx)] = out
+		}
+	}
+
+	if block.Transactions[0].OutputValue() > ActiveNetParams.BlockReward+fees {
+		return ErrBadCoinbaseValue
+	}
+
+	return nil
+}
+
+// VerifyTransaction checks tx against the current UTXO set alone, as a
+// candidate for the next block.
+func (chain *BlockChain) VerifyTransaction(tx *Transaction) error {
+	return chain.VerifyPoolTransaction(tx, nil)
+}
+
+// VerifyPoolTransaction is VerifyTransaction for a memory pool, whose
+// transactions may also spend poolOutputs, the outputs of other pooled
+// transactions keyed by "txid:index". ErrNonFinalTx is only returned for
+// a transaction that passes every other check.
+func (chain *BlockChain) VerifyPoolTransaction(tx *Transaction, poolOutputs map[string]TxOutput) error {
+	if tx.IsCoinbase() {
+		return ErrBadCoinbase
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return ErrBadTxID
+	}
+	if err := tx.CheckOutputValues(); err != nil {
+		return err
+	}
+
+	return chain.Store.View(func(txn StoreTxn) error {
+		inputValue := 0
+		spent := make(map[string]bool)
+
+		for _, in := range tx.Inputs {
+			outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+			if spent[outpoint] {
+				return fmt.Errorf("%w: %s", ErrDoubleSpend, outpoint)
+			}
+			spent[outpoint] = true
+
+			out, ok := poolOutputs[outpoint]
+			if !ok {
+				entry, err := txn.GetUTXOEntry(in.ID, in.Out)
+				if err != nil {
+					return fmt.Errorf("%w: %s", ErrMissingInput, outpoint)
+				}
+				out = entry.Output()
+			}
+			if !in.CanUnlock(out.PubKey) {
+				return fmt.Errorf("%w: %s", ErrBadSignature, outpoint)
+			}
+			inputValue += out.Value
+			if !inMoneyRange(out.Value) || !inMoneyRange(inputValue) {
+				return ErrValueOutOfRange
+			}
+		}
+
+		if tx.OutputValue() > inputValue {
+			return ErrInsufficientFunds
+		}
+
+		tipHash, err := txn.GetTip()
+		if err != nil {
+			return err
+		}
+		tip, err := txn.GetHeader(tipHash)
+		if err != nil {
+			return err
+		}
+		mtp, err := medianTimePast(txn, tip)
+		if err != nil {
+			return err
+		}
+		if !tx.IsFinal(tip.Height+1, mtp) {
+			return ErrNonFinalTx
+		}
+
+		return nil
+	})
+}
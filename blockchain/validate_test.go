@@ -0,0 +1,168 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// newTestChain returns a regtest chain kept in memory with one block mined
+// on top of the genesis block, paying its reward to address.
+func newTestChain(t *testing.T, address string) (*BlockChain, *Block) {
+	t.Helper()
+
+	if err := SelectNetwork(RegTestParams.Name); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := CreateBlockChain(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain, mineTestBlock(t, chain, address)
+}
+
+func mineTestBlock(t *testing.T, chain *BlockChain, address string, txs ...*Transaction) *Block {
+	t.Helper()
+
+	cbTx := CoinbaseTx(address, "", ActiveNetParams.BlockReward)
+	block, _, err := chain.MineBlock(context.Background(), append([]*Transaction{cbTx}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func TestOutputValueOverflow(t *testing.T) {
+	chain, block := newTestChain(t, "alice")
+	cbTx := block.Transactions[0]
+
+	tx := &Transaction{
+		Inputs: []TxInput{{ID: cbTx.ID, Out: 0, Sig: "alice"}},
+		Outputs: []TxOutput{
+			{1 << 62, "bob"}, {1 << 62, "bob"}, {1 << 62, "bob"}, {1 << 62, "bob"},
+			{cbTx.Outputs[0].Value, "bob"},
+		},
+	}
+	tx.ID = tx.Hash()
+
+	if err := chain.VerifyTransaction(tx); !errors.Is(err, ErrBadOutputValue) {
+		t.Errorf("VerifyTransaction = %v, want %v", err, ErrBadOutputValue)
+	}
+
+	txs := []*Transaction{CoinbaseTx("alice", "", ActiveNetParams.BlockReward), tx}
+	sanity := &Block{Transactions: txs}
+	sanity.MerkleRoot = sanity.HashTransactions()
+	if err := CheckBlockSanity(sanity); !errors.Is(err, ErrBadOutputValue) {
+		t.Errorf("CheckBlockSanity = %v, want %v", err, ErrBadOutputValue)
+	}
+
+	if _, _, err := chain.MineBlock(context.Background(), txs); !errors.Is(err, ErrBadOutputValue) {
+		t.Errorf("MineBlock = %v, want %v", err, ErrBadOutputValue)
+	}
+}
+
+func TestTotalValueOverflow(t *testing.T) {
+	chain, block := newTestChain(t, "alice")
+	cbTx := block.Transactions[0]
+
+	tx := &Transaction{
+		Inputs:  []TxInput{{ID: cbTx.ID, Out: 0, Sig: "alice"}},
+		Outputs: []TxOutput{{MaxMoney, "bob"}, {1, "bob"}},
+	}
+	tx.ID = tx.Hash()
+
+	if err := chain.VerifyTransaction(tx); !errors.Is(err, ErrValueOutOfRange) {
+		t.Errorf("VerifyTransaction = %v, want %v", err, ErrValueOutOfRange)
+	}
+}
+
+func TestCheckOrphan(t *testing.T) {
+	chain, block := newTestChain(t, "alice")
+
+	if err := chain.CheckOrphan(block); err != nil {
+		t.Fatalf("CheckOrphan of a mined block = %v", err)
+	}
+
+	// A junk block claiming the hash of a real one.
+	junk := *block
+	junk.Transactions = []*Transaction{CoinbaseTx("mallory", "", ActiveNetParams.BlockReward)}
+	junk.MerkleRoot = junk.HashTransactions()
+	if err := chain.CheckOrphan(&junk); !errors.Is(err, ErrBadBlockHash) {
+		t.Errorf("CheckOrphan of a block under a foreign hash = %v, want %v", err, ErrBadBlockHash)
+	}
+
+	// The same block with its hash recomputed but no work done for it.
+	for junk.Hash = junk.BlockHeader.Hash(); NewProof(&junk.BlockHeader).Validate(); junk.Hash = junk.BlockHeader.Hash() {
+		junk.Nonce++
+	}
+	if err := chain.CheckOrphan(&junk); !errors.Is(err, ErrBadProofOfWork) {
+		t.Errorf("CheckOrphan of an unsolved block = %v, want %v", err, ErrBadProofOfWork)
+	}
+
+	junk.Transactions = nil
+	junk.Hash = junk.BlockHeader.Hash()
+	if err := chain.CheckOrphan(&junk); !errors.Is(err, ErrNoTransactions) {
+		t.Errorf("CheckOrphan of an empty block = %v, want %v", err, ErrNoTransactions)
+	}
+}
+
+func TestDuplicateCoinbase(t *testing.T) {
+	chain, _ := newTestChain(t, "alice")
+
+	cbTx := CoinbaseTx("dup", "same", ActiveNetParams.BlockReward)
+	if _, _, err := chain.MineBlock(context.Background(), []*Transaction{cbTx}); err != nil {
+		t.Fatal(err)
+	}
+	before := utxoSnapshot(t, chain)
+
+	again := CoinbaseTx("dup", "same", ActiveNetParams.BlockReward)
+	if _, _, err := chain.MineBlock(context.Background(), []*Transaction{again}); !errors.Is(err, ErrOverwritesUTXO) {
+		t.Fatalf("MineBlock of a duplicate coinbase = %v, want %v", err, ErrOverwritesUTXO)
+	}
+
+	if after := utxoSnapshot(t, chain); !reflect.DeepEqual(before, after) {
+		t.Errorf("UTXO set changed from %v to %v", before, after)
+	}
+}
+
+func TestVerifyPoolTransaction(t *testing.T) {
+	chain, block := newTestChain(t, "alice")
+	cbTx := block.Transactions[0]
+	reward := cbTx.Outputs[0].Value
+
+	spend := &Transaction{
+		Inputs:  []TxInput{{ID: cbTx.ID, Out: 0, Sig: "alice"}},
+		Outputs: []TxOutput{{Value: reward, PubKey: "bob"}},
+	}
+	spend.ID = spend.Hash()
+	if err := chain.VerifyPoolTransaction(spend, nil); err != nil {
+		t.Fatalf("VerifyPoolTransaction of a chain spend = %v", err)
+	}
+
+	child := &Transaction{
+		Inputs:  []TxInput{{ID: spend.ID, Out: 0, Sig: "bob"}},
+		Outputs: []TxOutput{{Value: reward, PubKey: "carol"}},
+	}
+	child.ID = child.Hash()
+	if err := chain.VerifyPoolTransaction(child, nil); !errors.Is(err, ErrMissingInput) {
+		t.Errorf("VerifyPoolTransaction without the pool = %v, want %v", err, ErrMissingInput)
+	}
+
+	pool := map[string]TxOutput{fmt.Sprintf("%x:0", spend.ID): spend.Outputs[0]}
+	if err := chain.VerifyPoolTransaction(child, pool); err != nil {
+		t.Errorf("VerifyPoolTransaction of a pool spend = %v", err)
+	}
+
+	forged := &Transaction{
+		Inputs:  []TxInput{{ID: spend.ID, Out: 0, Sig: "mallory"}},
+		Outputs: []TxOutput{{Value: reward, PubKey: "mallory"}},
+	}
+	forged.ID = forged.Hash()
+	if err := chain.VerifyPoolTransaction(forged, pool); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifyPoolTransaction of a forged spend = %v, want %v", err, ErrBadSignature)
+	}
+}
@@ -1,13 +1,16 @@
 package cli
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"net"
+	"math/big"
 	"os"
 	"runtime"
 	"strconv"
+	"time"
 
 	"github.com/patiparnphot/decentralize-utxos-blockchain/blockchain"
 	"github.com/patiparnphot/decentralize-utxos-blockchain/network"
@@ -18,14 +21,34 @@ type CommandLine struct{}
 func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
+	fmt.Println(" createblockchain -address ADDRESS creates a blockchain from the network genesis and mines a first block paying ADDRESS")
 	fmt.Println(" print - Prints the blocks in the chain")
 	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send amount of coins")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -fee FEE - Send amount of coins and pay FEE to the miner")
 	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag is set, mine off of this node")
 	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine -bootnode BOOTNODE - Send amount of coins. Then -mine flag is set, mine off of this node. Then -bootnode flag is set to connect with BOOTNODE.")
 	fmt.Println(" reindexutxo - Rebuilds the UTXO set")
+	fmt.Println(" getdifficulty - Prints the target of the chain tip and of the next block")
+	fmt.Println(" getblockhash -height HEIGHT - Prints the hash of the block at HEIGHT in the active chain")
+	fmt.Println(" gettransaction -txid TXID - Prints the transaction TXID, its block and its confirmations. Needs the txindex")
+	fmt.Println(" listtransactions -address ADDRESS -from N -count C - Prints C transactions paying or spending from ADDRESS, skipping the first N. Needs the addrindex")
+	fmt.Println(" getspendinginfo -txid TXID -vout N - Prints the transaction and block spending output N of TXID. Needs the spentindex")
+	fmt.Println(" getspendinginfo -txid TXID -vout N -node ADDR - Asks the node on ADDR instead, which also looks at its memory pool")
+	fmt.Println(" createblockchain|startnode|gettransaction|listtransactions|getspendinginfo -txindex -addrindex -spentindex - Build and keep the txid, address or spent-output index from now on")
 	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
 	fmt.Println(" startnode -miner ADDRESS -bootnode BOOTNODE - Start a node with ID specified in NODE_ID env. var. -miner enables mining. Then -bootnode flag is set to connect with BOOTNODE.")
+	fmt.Println(" startnode -maxdrift SECONDS - Reject blocks stamped more than SECONDS ahead of network-adjusted time")
+	fmt.Println(" startnode -minethreads N - Mine with N goroutines")
+	fmt.Println(" createsignerkey -out FILE - Creates a pos staker key, or a key for a new poa signer set, in FILE and prints its public key")
+	fmt.Println(" startnode -network poa -signerkey FILE - Seal blocks of the poa network with the key in FILE, which must be one of its signers. createblockchain and send take the same flag")
+	fmt.Println(" createblockchain|send|startnode -network pos -signerkey FILE - Stake outputs paid to the public key of FILE once the pos network is past its proof-of-work blocks")
+	fmt.Println(" getblocktemplate -node ADDR - Prints the block template of the node listening on ADDR")
+	fmt.Println(" minetemplate -node ADDR -address ADDRESS -threads N - Mines the template of the node on ADDR outside of it, paying ADDRESS, and submits the block")
+	fmt.Println(" startnode -minepolicy ontx|minfee|interval|continuous -minfee FEES -mineinterval SECONDS - Mine on every new transaction, once the pool pays FEES, every SECONDS, or block after block")
+	fmt.Println(" setgenerate -node ADDR -address ADDRESS on|off - Turns mining of the node on ADDR on or off, paying ADDRESS if given")
+	fmt.Println(" generate -node ADDR -blocks N -address ADDRESS - Has the regtest node on ADDR mine N blocks with its pooled transactions right away, paying ADDRESS")
+	fmt.Println(" getmininginfo -node ADDR - Prints the difficulty and the local and network hash rates seen by the node on ADDR")
+	fmt.Println(" Every command accepts -network NAME to pick mainnet (default), testnet, regtest, poa or pos")
 }
 
 func (cli *CommandLine) validateArgs() {
@@ -43,8 +66,8 @@ func (cli *CommandLine) StartNode(nodeID, minerAddress, bootnode string) {
 
 func (cli *CommandLine) reindexUTXO(nodeId string) {
 	chain := blockchain.ResumeBlockChain(nodeId)
-	defer chain.Database.Close()
-	UTXOSet := blockchain.UTXOSet{chain}
+	defer chain.Store.Close()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	UTXOSet.Reindex()
 
 	count := UTXOSet.CountTransactions()
@@ -53,7 +76,7 @@ func (cli *CommandLine) reindexUTXO(nodeId string) {
 
 func (cli *CommandLine) printChain(nodeId string) {
 	chain := blockchain.ResumeBlockChain(nodeId)
-	defer chain.Database.Close()
+	defer chain.Store.Close()
 
 	iter := chain.Iterator()
 
@@ -62,11 +85,22 @@ func (cli *CommandLine) printChain(nodeId string) {
 
 		fmt.Printf("Previous hash: %x\n", block.PrevHash)
 		fmt.Printf("Hash: %x\n", block.Hash)
+		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+		fmt.Printf("Timestamp: %d\n", block.Timestamp)
+		fmt.Printf("Bits: %08x\n", block.Bits)
 		fmt.Printf("Nonce: %d\n", block.Nonce)
 
-		pow := blockchain.NewProof(block)
+		if len(block.StakeTxID) > 0 {
+			fmt.Printf("Stake: %x:%d, %d from height %d of %s\n", block.StakeTxID, block.StakeOut, block.StakeValue, block.StakeHeight, block.StakePubKey)
+		}
+		if len(block.Signature) > 0 {
+			fmt.Printf("Signature: %x\n", block.Signature)
+		} else if blockchain.ActiveNetParams.Consensus != blockchain.PoAConsensus {
+			pow := blockchain.NewProof(&block.BlockHeader)
 
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+			fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		}
 		for _, tx := range block.Transactions {
 			fmt.Printf("Transaction Inputs: %v\n", tx.Inputs)
 			fmt.Printf("Transaction Outputs: %v\n", tx.Outputs)
@@ -79,20 +113,146 @@ func (cli *CommandLine) printChain(nodeId string) {
 	}
 }
 
+func (cli *CommandLine) getDifficulty(nodeId string) {
+	chain := blockchain.ResumeBlockChain(nodeId)
+	defer chain.Store.Close()
+
+	tip, err := chain.GetHeader(chain.Tip())
+	blockchain.Handle(err)
+
+	nextBits, err := chain.CalcNextBits(chain.Tip())
+	blockchain.Handle(err)
+
+	fmt.Printf("Height: %d\n", tip.Height)
+	fmt.Printf("Bits: %08x\n", tip.Bits)
+	fmt.Printf("Target: %064x\n", blockchain.CompactToBig(tip.Bits))
+	fmt.Printf("Difficulty: %f\n", blockchain.GetDifficulty(tip.Bits))
+	fmt.Printf("Next bits: %08x\n", nextBits)
+	fmt.Printf("Next difficulty: %f\n", blockchain.GetDifficulty(nextBits))
+}
+
+func (cli *CommandLine) getBlockHash(height int, nodeId string) {
+	chain := blockchain.ResumeBlockChain(nodeId)
+	defer chain.Store.Close()
+
+	block, err := chain.GetBlockByHeight(height)
+	blockchain.Handle(err)
+
+	fmt.Printf("%x\n", block.Hash)
+}
+
+func (cli *CommandLine) getTransaction(txID, nodeId string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		fmt.Println("txid is not hex:", err)
+		runtime.Goexit()
+	}
+
+	chain := blockchain.ResumeBlockChain(nodeId)
+	defer chain.Store.Close()
+
+	tx, loc, err := chain.FindTransaction(id)
+	if err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+
+	tip, err := chain.GetHeader(chain.Tip())
+	blockchain.Handle(err)
+
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Printf("Block: %x\n", loc.BlockHash)
+	fmt.Printf("Height: %d\n", loc.Height)
+	fmt.Printf("Position: %d\n", loc.Position)
+	fmt.Printf("Confirmations: %d\n", tip.Height-loc.Height+1)
+	fmt.Printf("Transaction Inputs: %v\n", tx.Inputs)
+	fmt.Printf("Transaction Outputs: %v\n", tx.Outputs)
+}
+
+func (cli *CommandLine) listTransactions(address string, from, count int, nodeId string) {
+	chain := blockchain.ResumeBlockChain(nodeId)
+	defer chain.Store.Close()
+
+	history, total, err := chain.AddressHistory(address, from, count)
+	if err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+
+	tip, err := chain.GetHeader(chain.Tip())
+	blockchain.Handle(err)
+
+	for _, entry := range history {
+		fmt.Printf("Transaction: %x\n", entry.TxID)
+		fmt.Printf("Block: %x\n", entry.BlockHash)
+		fmt.Printf("Height: %d\n", entry.Height)
+		fmt.Printf("Confirmations: %d\n", tip.Height-entry.Height+1)
+		fmt.Printf("Received: %d\n", entry.Received)
+		fmt.Printf("Sent: %d\n", entry.Sent)
+		fmt.Println()
+	}
+	fmt.Printf("Listed %d of %d transactions of %s from %d\n", len(history), total, address, from)
+}
+
+// getSpendingInfo reads the local chain, or asks node when it is set so
+// spends waiting in its memory pool are found too.
+func (cli *CommandLine) getSpendingInfo(txID string, vout int, node, nodeId string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		fmt.Println("txid is not hex:", err)
+		runtime.Goexit()
+	}
+
+	var info *network.SpendingInfoResult
+	if node != "" {
+		info, err = network.GetSpendingInfo(node, id, vout)
+	} else {
+		chain := blockchain.ResumeBlockChain(nodeId)
+		result := network.FindSpendingInfo(chain, id, vout)
+		chain.Store.Close()
+
+		info = &result
+		if result.Error != "" {
+			err = errors.New(result.Error)
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+
+	output := "Output"
+	if info.Unconfirmed {
+		output = "Unconfirmed output"
+	}
+	switch {
+	case !info.Spent:
+		fmt.Printf("%s %x:%d is unspent\n", output, id, vout)
+	case info.Mempool:
+		fmt.Printf("Spent by input %d of %x in the memory pool\n", info.Input, info.TxID)
+	default:
+		fmt.Printf("Spent by input %d of %x\n", info.Input, info.TxID)
+		fmt.Printf("Block: %x\n", info.BlockHash)
+		fmt.Printf("Height: %d\n", info.Height)
+	}
+}
+
 func (cli *CommandLine) createBlockchain(address, nodeId string) {
-	chain := blockchain.InitBlockChain(address, nodeId)
-	defer chain.Database.Close()
+	chain := blockchain.InitBlockChain(nodeId)
+	defer chain.Store.Close()
 
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
+	cbTx := blockchain.CoinbaseTx(address, "", blockchain.ActiveNetParams.BlockReward)
+	block, _, err := chain.MineBlock(context.Background(), []*blockchain.Transaction{cbTx})
+	blockchain.Handle(err)
+	fmt.Printf("Mined block %x paying %s\n", block.Hash, address)
 
 	fmt.Println("Finished!!!")
 }
 
 func (cli *CommandLine) getBalance(address, nodeId string) {
 	chain := blockchain.ResumeBlockChain(nodeId)
-	UTXOSet := blockchain.UTXOSet{chain}
-	defer chain.Database.Close()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Store.Close()
 
 	balance := 0
 	UTXOs := UTXOSet.FindUTXO(address)
@@ -104,24 +264,24 @@ func (cli *CommandLine) getBalance(address, nodeId string) {
 	fmt.Printf("Balance of %s: %d\n", address, balance)
 }
 
-func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow bool, bootnode string) {
-	path := fmt.Sprintf(blockchain.DbPath, nodeId)
+func (cli *CommandLine) send(from, to string, amount, fee int, nodeId string, mineNow bool, bootnode string) {
+	path := blockchain.DBPath(nodeId)
 	var chain *blockchain.BlockChain
 
 	if blockchain.DBexists(path) {
 		chain = blockchain.ResumeBlockChain(nodeId)
 		fmt.Println("Resumed chain")
 
-		defer chain.Database.Close()
-		UTXOSet := blockchain.UTXOSet{chain}
+		defer chain.Store.Close()
+		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 		UTXOSet.Reindex()
 
-		tx := blockchain.NewTransaction(from, to, amount, &UTXOSet)
+		tx := blockchain.NewTransaction(from, to, amount, fee, &UTXOSet)
 		if mineNow {
-			cbTx := blockchain.CoinbaseTx(from, "")
+			cbTx := blockchain.CoinbaseTx(from, "", blockchain.ActiveNetParams.BlockReward+fee)
 			txs := []*blockchain.Transaction{cbTx, tx}
-			block := chain.MineBlock(txs)
-			UTXOSet.Update(block)
+			_, _, err := chain.MineBlock(context.Background(), txs)
+			blockchain.Handle(err)
 			fmt.Println("Transfer & Mine Success!!!")
 		} else if bootnode != "" {
 			network.KnownNodes[0] = bootnode
@@ -131,38 +291,127 @@ func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow
 			fmt.Println("Please enter bootnode or mine")
 		}
 	} else if bootnode == "" {
-		chain = blockchain.InitBlockChain(from, nodeId)
-		fmt.Printf("Created new chain with %s\n", from)
-
-		defer chain.Database.Close()
-		UTXOSet := blockchain.UTXOSet{chain}
-		UTXOSet.Reindex()
+		cli.createBlockchain(from, nodeId)
+		fmt.Println("Please enter the command again")
+	} else {
+		chain = blockchain.InitBlockChain(nodeId)
+		chain.Store.Close()
 
+		fmt.Printf("Created new %s chain, run startnode -bootnode %s to sync it\n", blockchain.ActiveNetParams.Name, bootnode)
 		fmt.Println("Please enter the command again")
+	}
+}
+
+func (cli *CommandLine) getBlockTemplate(node string) {
+	tmpl, err := network.GetBlockTemplate(node)
+	blockchain.Handle(err)
+
+	fmt.Printf("Version: %d\n", tmpl.Version)
+	fmt.Printf("Previous hash: %x\n", tmpl.PrevHash)
+	fmt.Printf("Height: %d\n", tmpl.Height)
+	fmt.Printf("Bits: %08x\n", tmpl.Bits)
+	fmt.Printf("Target: %064x\n", new(big.Int).SetBytes(tmpl.Target))
+	fmt.Printf("Min time: %d\n", tmpl.MinTime)
+	fmt.Printf("Current time: %d\n", tmpl.CurTime)
+	fmt.Printf("Coinbase value: %d\n", tmpl.CoinbaseValue)
+	fmt.Printf("Fees: %d\n", tmpl.Fees)
+	for _, data := range tmpl.Transactions {
+		tx := blockchain.DeserializeTransaction(data)
+		fmt.Printf("Transaction: %x\n", tx.ID)
+	}
+}
+
+func (cli *CommandLine) getMiningInfo(node string) {
+	info, err := network.GetMiningInfo(node)
+	blockchain.Handle(err)
+
+	fmt.Printf("Blocks: %d\n", info.Blocks)
+	fmt.Printf("Bits: %08x\n", info.Bits)
+	fmt.Printf("Difficulty: %f\n", info.Difficulty)
+	fmt.Printf("Local hash rate: %.2f H/s\n", info.LocalHashRate)
+	fmt.Printf("Network hash rate: %.2f H/s\n", info.NetworkHashRate)
+	fmt.Printf("Pooled transactions: %d\n", info.PooledTx)
+	fmt.Printf("Generating: %s\n", strconv.FormatBool(info.Generating))
+}
+
+func (cli *CommandLine) setGenerate(node string, on bool, address string) {
+	result, err := network.SendSetGenerate(node, on, address)
+	blockchain.Handle(err)
+
+	if result.Generating {
+		fmt.Printf("Mining to %s\n", result.Address)
 	} else {
-		network.NodeAddress = fmt.Sprintf(":%s", nodeId)
+		fmt.Println("Mining is off")
+	}
+}
 
-		ln, err := net.Listen(network.Protocol, network.NodeAddress)
+func (cli *CommandLine) generate(node string, blocks int, address string) {
+	hashes, err := network.SendGenerate(node, blocks, address)
+	for _, hash := range hashes {
+		fmt.Printf("%x\n", hash)
+	}
+	blockchain.Handle(err)
+}
 
-		network.KnownNodes[0] = bootnode
-		network.SendBootnode(bootnode)
+func (cli *CommandLine) printMiningProgress(p blockchain.MiningProgress) {
+	fmt.Printf("Mining block %d: %d hashes in %s, %.0f H/s\n", p.Height, p.Hashes, p.Elapsed.Round(time.Millisecond), p.HashRate())
+}
 
-		conn, err := ln.Accept()
-		blockchain.Handle(err)
+// mineTemplate mines one block the way an external miner does: it fetches
+// a template from the node, solves it here and submits the result.
+func (cli *CommandLine) mineTemplate(node, address string, threads int) {
+	tmpl, err := network.GetBlockTemplate(node)
+	blockchain.Handle(err)
 
-		req, err := ioutil.ReadAll(conn)
-		conn.Close()
-		blockchain.Handle(err)
+	cbTx := blockchain.CoinbaseTx(address, "", tmpl.CoinbaseValue)
+	block := tmpl.BlockTemplate().NewBlock(cbTx)
 
-		command := network.BytesToCmd(req[:network.CommandLength])
-		fmt.Printf("Received %s command\n", command)
+	ctx, cancel := context.WithTimeout(context.Background(), network.TemplateMaxAge)
+	defer cancel()
 
-		chain = network.HandleGenesis(req)
-		defer chain.Database.Close()
+	err = blockchain.SolveBlock(ctx, block, threads)
+	blockchain.Handle(err)
 
-		network.SendVersion(bootnode, chain)
-		fmt.Println("Please enter the command again")
+	err = network.SubmitSolvedBlock(node, block)
+	blockchain.Handle(err)
+
+	fmt.Printf("Submitted block %x at height %d\n", block.Hash, block.Height)
+}
+
+func (cli *CommandLine) createSignerKey(path string) {
+	key, err := blockchain.NewSignerKey()
+	blockchain.Handle(err)
+
+	err = blockchain.SaveSignerKey(path, key)
+	blockchain.Handle(err)
+
+	fmt.Printf("Signer public key: %s\n", blockchain.SignerPubKey(key))
+}
+
+// setSigner loads the key this node signs blocks with.
+func (cli *CommandLine) setSigner(keyPath string) {
+	key, err := blockchain.LoadSignerKey(keyPath)
+	blockchain.Handle(err)
+
+	blockchain.SignerKey = key
+}
+
+func validMinePolicy(policy string) bool {
+	for _, p := range network.MinePolicies {
+		if p == policy {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (cli *CommandLine) selectNetwork(name string) {
+	if err := blockchain.SelectNetwork(name); err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
 	}
+	network.KnownNodes = append([]string{}, blockchain.ActiveNetParams.SeedNodes...)
 }
 
 func (cli *CommandLine) Run() {
@@ -179,17 +428,72 @@ func (cli *CommandLine) Run() {
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("print", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	getDifficultyCmd := flag.NewFlagSet("getdifficulty", flag.ExitOnError)
+	getBlockHashCmd := flag.NewFlagSet("getblockhash", flag.ExitOnError)
+	getTransactionCmd := flag.NewFlagSet("gettransaction", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	getSpendingInfoCmd := flag.NewFlagSet("getspendinginfo", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	createSignerKeyCmd := flag.NewFlagSet("createsignerkey", flag.ExitOnError)
+	getBlockTemplateCmd := flag.NewFlagSet("getblocktemplate", flag.ExitOnError)
+	getMiningInfoCmd := flag.NewFlagSet("getmininginfo", flag.ExitOnError)
+	setGenerateCmd := flag.NewFlagSet("setgenerate", flag.ExitOnError)
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
+	mineTemplateCmd := flag.NewFlagSet("minetemplate", flag.ExitOnError)
+
+	networks := make(map[*flag.FlagSet]*string)
+	for _, cmd := range []*flag.FlagSet{getBalanceCmd, createBlockchainCmd, sendCmd, printChainCmd, reindexUTXOCmd, getDifficultyCmd, getBlockHashCmd, getTransactionCmd, listTransactionsCmd, getSpendingInfoCmd, startNodeCmd, getBlockTemplateCmd, getMiningInfoCmd, setGenerateCmd, generateCmd, mineTemplateCmd} {
+		networks[cmd] = cmd.String("network", blockchain.MainNetParams.Name, "network to use: mainnet, testnet, regtest, poa or pos")
+	}
+
+	signerKeys := make(map[*flag.FlagSet]*string)
+	for _, cmd := range []*flag.FlagSet{createBlockchainCmd, sendCmd, startNodeCmd} {
+		signerKeys[cmd] = cmd.String("signerkey", "", "file holding the key this node signs poa and pos blocks with")
+	}
+
+	indexes := make(map[*flag.FlagSet]map[string]*bool)
+	for _, cmd := range []*flag.FlagSet{createBlockchainCmd, startNodeCmd, getTransactionCmd, listTransactionsCmd, getSpendingInfoCmd} {
+		indexes[cmd] = map[string]*bool{
+			blockchain.TxIndexName:    cmd.Bool(blockchain.TxIndexName, false, "build and keep the index of transactions by txid"),
+			blockchain.AddrIndexName:  cmd.Bool(blockchain.AddrIndexName, false, "build and keep the index of transactions by address"),
+			blockchain.SpentIndexName: cmd.Bool(blockchain.SpentIndexName, false, "build and keep the index of spent outputs"),
+		}
+	}
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "the address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "the address to send genesis reward to")
 	sendFrom := sendCmd.String("from", "", "sender address")
 	sendTo := sendCmd.String("to", "", "receiver address")
 	sendAmount := sendCmd.Int("amount", 0, "amount to send")
+	sendFee := sendCmd.Int("fee", 0, "fee left for the miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
 	sendBootnode := sendCmd.String("bootnode", "", "Enable bootnode mode")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
 	startNodeBootnode := startNodeCmd.String("bootnode", "", "Enable bootnode mode")
+	getBlockHashHeight := getBlockHashCmd.Int("height", -1, "height of the block")
+	getTransactionTxID := getTransactionCmd.String("txid", "", "hex ID of the transaction")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "the address to list transactions for")
+	listTransactionsFrom := listTransactionsCmd.Int("from", 0, "number of transactions to skip, oldest first")
+	listTransactionsCount := listTransactionsCmd.Int("count", 10, "number of transactions to print")
+	getSpendingInfoTxID := getSpendingInfoCmd.String("txid", "", "hex ID of the transaction holding the output")
+	getSpendingInfoVout := getSpendingInfoCmd.Int("vout", -1, "index of the output")
+	getSpendingInfoNode := getSpendingInfoCmd.String("node", "", "address of the node to ask, e.g. localhost:3000")
+	createSignerKeyOut := createSignerKeyCmd.String("out", "", "file to write the key to")
+	getBlockTemplateNode := getBlockTemplateCmd.String("node", "", "address of the node, e.g. localhost:3000")
+	getMiningInfoNode := getMiningInfoCmd.String("node", "", "address of the node, e.g. localhost:3000")
+	setGenerateNode := setGenerateCmd.String("node", "", "address of the node, e.g. localhost:3000")
+	setGenerateAddress := setGenerateCmd.String("address", "", "the address to pay mined blocks to")
+	generateNode := generateCmd.String("node", "", "address of the node, e.g. localhost:3000")
+	generateBlocks := generateCmd.Int("blocks", 1, "number of blocks to mine")
+	generateAddress := generateCmd.String("address", "", "the address to pay the block rewards to")
+	mineTemplateNode := mineTemplateCmd.String("node", "", "address of the node, e.g. localhost:3000")
+	mineTemplateAddress := mineTemplateCmd.String("address", "", "the address to pay the block reward to")
+	mineTemplateThreads := mineTemplateCmd.Int("threads", runtime.NumCPU(), "number of goroutines mining the block")
+	startNodeMineThreads := startNodeCmd.Int("minethreads", blockchain.MineThreads, "number of goroutines mining blocks")
+	startNodeMinePolicy := startNodeCmd.String("minepolicy", network.MinePolicy, "when to mine: ontx, minfee, interval or continuous")
+	startNodeMinFee := startNodeCmd.Int("minfee", network.MinFeeTotal, "fees the pool has to pay before the minfee policy mines")
+	startNodeMineInterval := startNodeCmd.Int("mineinterval", int(network.MineEvery/time.Second), "seconds between blocks of the interval policy")
+	startNodeMaxDrift := startNodeCmd.Int64("maxdrift", blockchain.MaxTimeDrift, "Seconds a block timestamp may be ahead of network-adjusted time")
 
 	switch os.Args[1] {
 	case "startnode":
@@ -216,11 +520,80 @@ func (cli *CommandLine) Run() {
 		err := reindexUTXOCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
 
+	case "getdifficulty":
+		err := getDifficultyCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "getblockhash":
+		err := getBlockHashCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "gettransaction":
+		err := getTransactionCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "getspendinginfo":
+		err := getSpendingInfoCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "createsignerkey":
+		err := createSignerKeyCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "getblocktemplate":
+		err := getBlockTemplateCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "getmininginfo":
+		err := getMiningInfoCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "setgenerate":
+		err := setGenerateCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "generate":
+		err := generateCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "minetemplate":
+		err := mineTemplateCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
 	default:
 		cli.printUsage()
 		runtime.Goexit()
 	}
 
+	blockchain.OnMiningProgress = cli.printMiningProgress
+
+	for cmd, name := range networks {
+		if cmd.Parsed() {
+			cli.selectNetwork(*name)
+		}
+	}
+
+	for cmd, keyPath := range signerKeys {
+		if cmd.Parsed() && *keyPath != "" {
+			cli.setSigner(*keyPath)
+		}
+	}
+
+	for cmd, flags := range indexes {
+		if !cmd.Parsed() {
+			continue
+		}
+		for name, enable := range flags {
+			if *enable {
+				blockchain.EnableIndexes = append(blockchain.EnableIndexes, name)
+			}
+		}
+	}
+
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
@@ -240,11 +613,11 @@ func (cli *CommandLine) Run() {
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 {
 			sendCmd.Usage()
 			runtime.Goexit()
 		} else {
-			cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine, *sendBootnode)
+			cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, nodeID, *sendMine, *sendBootnode)
 		}
 	}
 
@@ -256,11 +629,112 @@ func (cli *CommandLine) Run() {
 		cli.reindexUTXO(nodeID)
 	}
 
+	if getDifficultyCmd.Parsed() {
+		cli.getDifficulty(nodeID)
+	}
+
+	if getBlockHashCmd.Parsed() {
+		if *getBlockHashHeight < 0 {
+			getBlockHashCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getBlockHash(*getBlockHashHeight, nodeID)
+	}
+
+	if getTransactionCmd.Parsed() {
+		if *getTransactionTxID == "" {
+			getTransactionCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getTransaction(*getTransactionTxID, nodeID)
+	}
+
+	if listTransactionsCmd.Parsed() {
+		if *listTransactionsAddress == "" || *listTransactionsFrom < 0 || *listTransactionsCount < 1 {
+			listTransactionsCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.listTransactions(*listTransactionsAddress, *listTransactionsFrom, *listTransactionsCount, nodeID)
+	}
+
+	if getSpendingInfoCmd.Parsed() {
+		if *getSpendingInfoTxID == "" || *getSpendingInfoVout < 0 {
+			getSpendingInfoCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getSpendingInfo(*getSpendingInfoTxID, *getSpendingInfoVout, *getSpendingInfoNode, nodeID)
+	}
+
+	if createSignerKeyCmd.Parsed() {
+		if *createSignerKeyOut == "" {
+			createSignerKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createSignerKey(*createSignerKeyOut)
+	}
+
+	if getBlockTemplateCmd.Parsed() {
+		if *getBlockTemplateNode == "" {
+			getBlockTemplateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getBlockTemplate(*getBlockTemplateNode)
+	}
+
+	if getMiningInfoCmd.Parsed() {
+		if *getMiningInfoNode == "" {
+			getMiningInfoCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getMiningInfo(*getMiningInfoNode)
+	}
+
+	if setGenerateCmd.Parsed() {
+		if *setGenerateNode == "" {
+			setGenerateCmd.Usage()
+			runtime.Goexit()
+		}
+		switch setGenerateCmd.Arg(0) {
+		case "on":
+			cli.setGenerate(*setGenerateNode, true, *setGenerateAddress)
+		case "off":
+			cli.setGenerate(*setGenerateNode, false, "")
+		default:
+			setGenerateCmd.Usage()
+			runtime.Goexit()
+		}
+	}
+
+	if generateCmd.Parsed() {
+		if *generateNode == "" || *generateAddress == "" || *generateBlocks < 1 {
+			generateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.generate(*generateNode, *generateBlocks, *generateAddress)
+	}
+
+	if mineTemplateCmd.Parsed() {
+		if *mineTemplateNode == "" || *mineTemplateAddress == "" || *mineTemplateThreads < 1 {
+			mineTemplateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.mineTemplate(*mineTemplateNode, *mineTemplateAddress, *mineTemplateThreads)
+	}
+
 	if startNodeCmd.Parsed() {
 		if nodeID == "" {
 			startNodeCmd.Usage()
 			runtime.Goexit()
 		}
+		if !validMinePolicy(*startNodeMinePolicy) || *startNodeMinFee < 0 || *startNodeMineInterval < 1 {
+			startNodeCmd.Usage()
+			runtime.Goexit()
+		}
+		network.MinePolicy = *startNodeMinePolicy
+		network.MinFeeTotal = *startNodeMinFee
+		network.MineEvery = time.Duration(*startNodeMineInterval) * time.Second
+		blockchain.MaxTimeDrift = *startNodeMaxDrift
+		blockchain.MineThreads = *startNodeMineThreads
 		cli.StartNode(nodeID, *startNodeMiner, *startNodeBootnode)
 	}
 }
@@ -0,0 +1,207 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/patiparnphot/decentralize-utxos-blockchain/blockchain"
+)
+
+const (
+	// TemplateMaxAge is how long a block template is mined before it is
+	// rebuilt to pick up newer transactions.
+	TemplateMaxAge = 30 * time.Second
+	// MineRetryMin and MineRetryMax bound how long the MineContinuous
+	// policy waits before it tries again after a block could not be
+	// built or sealed, say because it is not this node's turn to sign.
+	MineRetryMin = time.Second
+	MineRetryMax = time.Minute
+)
+
+// Mining policies decide when the miner builds a block.
+const (
+	// MineOnTx mines as soon as there are transactions to include.
+	MineOnTx = "ontx"
+	// MineMinFee waits until the pool pays at least MinFeeTotal in fees.
+	MineMinFee = "minfee"
+	// MineInterval mines one block every MineEvery, empty or not.
+	MineInterval = "interval"
+	// MineContinuous mines block after block, empty or not.
+	MineContinuous = "continuous"
+)
+
+// MinePolicies lists the policies MinePolicy can be set to.
+var MinePolicies = []string{MineOnTx, MineMinFee, MineInterval, MineContinuous}
+
+var (
+	// MinePolicy is the policy the miner follows.
+	MinePolicy = MineOnTx
+	// MinFeeTotal is the fee total the MineMinFee policy waits for.
+	MinFeeTotal = 1
+	// MineEvery is the block interval of the MineInterval policy.
+	MineEvery = time.Minute
+)
+
+var ErrNoMiningAddress = errors.New("no address to pay mined blocks to")
+
+var (
+	mineSignal = make(chan struct{}, 1)
+	// minerMu guards the fields below.
+	minerMu     sync.Mutex
+	cancelMine  context.CancelFunc
+	mineAddress string
+	generate    bool
+)
+
+// StartMiner runs the miner in its own goroutine, so connection handlers
+// never wait for a block to be mined. It mines whenever RequestMining is
+// called, or on every tick under the MineInterval policy, and keeps going
+// while MineTx asks for another round. Under MineContinuous a round that
+// failed is retried, waiting twice as long after each failure in a row up
+// to MineRetryMax. Nothing is mined until SetGenerate turns it on.
+func StartMiner(chain *blockchain.BlockChain) {
+	var tick <-chan time.Time
+	if MinePolicy == MineInterval {
+		tick = time.NewTicker(MineEvery).C
+	}
+
+	go func() {
+		var retry <-chan time.Time
+		backoff := MineRetryMin
+
+		for {
+			select {
+			case <-mineSignal:
+			case <-tick:
+			case <-retry:
+			}
+			retry = nil
+
+			for MineTx(chain) {
+				backoff = MineRetryMin
+			}
+
+			if _, on := Generating(); on && MinePolicy == MineContinuous {
+				retry = time.After(backoff)
+				if backoff *= 2; backoff > MineRetryMax {
+					backoff = MineRetryMax
+				}
+			}
+		}
+	}()
+}
+
+// SetGenerate turns mining on or off. Turning it on with an empty address
+// keeps the address it was last on with. Turning it off aborts the block
+// being mined.
+func SetGenerate(on bool, address string) error {
+	minerMu.Lock()
+
+	if on {
+		if address != "" {
+			mineAddress = address
+		}
+		if mineAddress == "" {
+			minerMu.Unlock()
+			return ErrNoMiningAddress
+		}
+		generate = true
+		address = mineAddress
+		minerMu.Unlock()
+
+		fmt.Printf("Mining to %s with the %s policy\n", address, MinePolicy)
+		if MinePolicy != MineInterval {
+			RequestMining()
+		}
+		return nil
+	}
+
+	generate = false
+	if cancelMine != nil {
+		cancelMine()
+	}
+	minerMu.Unlock()
+
+	fmt.Println("Mining stopped")
+
+	return nil
+}
+
+// Generating returns the address mined blocks pay to and whether mining is
+// on.
+func Generating() (string, bool) {
+	minerMu.Lock()
+	defer minerMu.Unlock()
+
+	return mineAddress, generate
+}
+
+// RequestMining wakes the miner. Requests made while it is busy are merged
+// into one.
+func RequestMining() {
+	select {
+	case mineSignal <- struct{}{}:
+	default:
+	}
+}
+
+// TxAdded tells the miner that a transaction entered the pool.
+func TxAdded() {
+	if _, on := Generating(); on && (MinePolicy == MineOnTx || MinePolicy == MineMinFee) {
+		RequestMining()
+	}
+}
+
+// readyToMine reports whether the policy lets a block be mined from tmpl.
+func readyToMine(tmpl *blockchain.BlockTemplate) bool {
+	switch MinePolicy {
+	case MineMinFee:
+		return len(tmpl.Transactions) > 0 && tmpl.Fees >= MinFeeTotal
+	case MineInterval, MineContinuous:
+		return true
+	default:
+		return len(tmpl.Transactions) > 0
+	}
+}
+
+// TipChanged aborts the block being mined, since it no longer builds on
+// the tip, and wakes the miner so it builds a template on the new tip even
+// if it was idle.
+func TipChanged() {
+	minerMu.Lock()
+	if cancelMine != nil {
+		cancelMine()
+	}
+	on := generate
+	minerMu.Unlock()
+
+	if on && MinePolicy != MineInterval {
+		RequestMining()
+	}
+}
+
+// newMiningContext returns the context of one mining round and registers
+// it for TipChanged and SetGenerate. The context is already canceled if
+// mining was turned off. The returned function must be called when the
+// round ends.
+func newMiningContext() (context.Context, func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), TemplateMaxAge)
+
+	minerMu.Lock()
+	cancelMine = cancel
+	if !generate {
+		cancel()
+	}
+	minerMu.Unlock()
+
+	return ctx, func() {
+		minerMu.Lock()
+		cancelMine = nil
+		minerMu.Unlock()
+
+		cancel()
+	}
+}
@@ -2,8 +2,10 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,7 +13,9 @@ import (
 	"net"
 	"os"
 	"runtime"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/vrecan/death/v3"
 
@@ -20,16 +24,18 @@ import (
 
 const (
 	Protocol      = "tcp"
-	version       = 1
 	CommandLength = 12
 )
 
 var (
 	NodeAddress     string
-	mineAddress     string
-	KnownNodes      = []string{"localhost:3000"}
+	KnownNodes      = append([]string{}, blockchain.ActiveNetParams.SeedNodes...)
 	blocksInTransit = [][]byte{}
 	memoryPool      = make(map[string]blockchain.Transaction)
+	// poolMu guards memoryPool.
+	poolMu sync.Mutex
+	// transitMu guards blocksInTransit.
+	transitMu sync.Mutex
 )
 
 type Addr struct {
@@ -63,9 +69,11 @@ type Tx struct {
 }
 
 type Version struct {
-	Version    int
-	BestHeight int
-	AddrFrom   string
+	Version     int
+	BestHeight  int
+	AddrFrom    string
+	Timestamp   int64
+	GenesisHash []byte
 }
 
 func CmdToBytes(cmd string) []byte {
@@ -117,14 +125,6 @@ func SendBlock(addr string, b *blockchain.Block) {
 	SendData(addr, request)
 }
 
-func SendGenesis(addr string, b *blockchain.Block) {
-	data := Block{NodeAddress, b.Serialize()}
-	payload := GobEncode(data)
-	request := append(CmdToBytes("genesis"), payload...)
-
-	SendData(addr, request)
-}
-
 func SendData(addr string, data []byte) {
 	conn, err := net.Dial(Protocol, addr)
 
@@ -181,16 +181,12 @@ func SendTx(addr string, tnx *blockchain.Transaction) {
 	SendData(addr, request)
 }
 
-func SendBootnode(addr string) {
-	payload := GobEncode(GetBlocks{NodeAddress})
-	request := append(CmdToBytes("bootnode"), payload...)
-
-	SendData(addr, request)
-}
-
 func SendVersion(addr string, chain *blockchain.BlockChain) {
 	bestHeight := chain.GetBestHeight()
-	payload := GobEncode(Version{version, bestHeight, NodeAddress})
+	genesisHash, err := hex.DecodeString(blockchain.ActiveNetParams.GenesisHash)
+	blockchain.Handle(err)
+
+	payload := GobEncode(Version{blockchain.ActiveNetParams.ProtocolVersion, bestHeight, NodeAddress, time.Now().Unix(), genesisHash})
 
 	request := append(CmdToBytes("version"), payload...)
 
@@ -222,53 +218,101 @@ func HandleBlock(request []byte, chain *blockchain.BlockChain, remoteIP string)
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		fmt.Printf("Undecodable block message from %s: %s\n", remoteIP, err)
+		Misbehaving(remoteIP, BanThreshold, err.Error())
+		return
 	}
 
-	blockData := payload.Block
-	block := blockchain.Deserialize(blockData)
+	block, err := blockchain.DecodeBlock(payload.Block)
+	if err != nil {
+		fmt.Printf("Undecodable block from %s: %s\n", remoteIP, err)
+		Misbehaving(remoteIP, BanThreshold, err.Error())
+		return
+	}
 
 	fmt.Println("Recevied a new block!")
-	chain.AddBlock(block)
 
-	fmt.Printf("Added block %x\n", block.Hash)
+	addrFrom := fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom)
+	if IsBanned(remoteIP) {
+		fmt.Printf("Ignoring block from banned peer %s\n", remoteIP)
+		return
+	}
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		SendGetData(fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom), "block", blockHash)
+	if !chain.HasBlock(block.PrevHash) {
+		if err := chain.CheckOrphan(block); err != nil {
+			fmt.Printf("Rejected orphan block %x: %s\n", block.Hash, err)
+			Misbehaving(remoteIP, BanThreshold, err.Error())
+			return
+		}
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{chain}
-		UTXOSet.Reindex()
+		missing := AddOrphan(block, remoteIP)
+		fmt.Printf("Holding orphan block %x, requesting parent %x\n", block.Hash, missing)
+		SendGetData(addrFrom, "block", missing)
+		return
 	}
-}
 
-func HandleGenesis(request []byte) *blockchain.BlockChain {
-	var buff bytes.Buffer
-	var payload Block
+	if AcceptBlock(chain, block, remoteIP) == nil {
+		ProcessOrphans(chain, block.Hash)
+	}
 
-	nodeID := os.Getenv("NODE_ID")
+	if blockHash, ok := nextBlockInTransit(); ok {
+		SendGetData(fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom), "block", blockHash)
+	}
+}
 
-	buff.Write(request[CommandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+// AcceptBlock validates a block whose parent is known and adds it to the
+// chain. The peer at remoteIP that relayed an invalid block is penalized. It
+// returns why the block was rejected, or nil once it is stored.
+func AcceptBlock(chain *blockchain.BlockChain, block *blockchain.Block, remoteIP string) error {
+	if err := chain.ValidateBlock(block); err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+		// A timestamp ahead of our clock may be our clock's fault.
+		if !errors.Is(err, blockchain.ErrTimeTooNew) {
+			Misbehaving(remoteIP, BanThreshold, err.Error())
+		}
+		return err
+	}
+
+	update, err := chain.AddBlock(block)
 	if err != nil {
-		log.Panic(err)
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+		Misbehaving(remoteIP, BanThreshold, err.Error())
+		return err
 	}
 
-	blockData := payload.Block
-	genesisBlock := blockchain.Deserialize(blockData)
+	if update != nil {
+		fmt.Printf("Added block %x\n", block.Hash)
+		if len(update.Disconnected) > 0 {
+			fmt.Printf("Reorganized chain: %d blocks disconnected, %d connected\n", len(update.Disconnected), len(update.Connected))
+		}
+		UpdateMemoryPool(update)
+		TipChanged()
+	} else {
+		fmt.Printf("Stored side chain block %x\n", block.Hash)
+	}
 
-	fmt.Println("Recevied genesis block!")
-	chain := blockchain.InitGenesis(*genesisBlock, nodeID)
+	return nil
+}
 
-	fmt.Printf("Genesis block %x\n", genesisBlock.Hash)
+// UpdateMemoryPool returns the transactions of disconnected blocks to the
+// pool and drops those that the newly connected blocks confirmed.
+func UpdateMemoryPool(update *blockchain.ChainUpdate) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
 
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
+	for _, block := range update.Disconnected {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				memoryPool[hex.EncodeToString(tx.ID)] = *tx
+			}
+		}
+	}
 
-	return chain
+	for _, block := range update.Connected {
+		for _, tx := range block.Transactions {
+			delete(memoryPool, hex.EncodeToString(tx.ID))
+		}
+	}
 }
 
 func HandleInv(request []byte, chain *blockchain.BlockChain, remoteIP string) {
@@ -284,32 +328,58 @@ func HandleInv(request []byte, chain *blockchain.BlockChain, remoteIP string) {
 
 	// fmt.Printf("AddrFrom: %s\n", payload.AddrFrom)
 
+	if IsBanned(remoteIP) {
+		return
+	}
+
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+		// The inventory lists the sender's tip first. Fetch the missing
+		// blocks oldest first so that each one arrives after its parent.
+		missing := [][]byte{}
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if !chain.HasBlock(payload.Items[i]) {
+				missing = append(missing, payload.Items[i])
+			}
+		}
 
-		blockHash := payload.Items[0]
-		SendGetData(fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom), "block", blockHash)
+		transitMu.Lock()
+		blocksInTransit = missing
+		transitMu.Unlock()
 
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
-			}
+		if blockHash, ok := nextBlockInTransit(); ok {
+			SendGetData(fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom), "block", blockHash)
 		}
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
+		poolMu.Lock()
+		_, known := memoryPool[hex.EncodeToString(txID)]
+		poolMu.Unlock()
+
+		if !known {
 			SendGetData(fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom), "tx", txID)
 		}
 	}
 }
 
+// nextBlockInTransit takes the next block to request off blocksInTransit.
+func nextBlockInTransit() ([]byte, bool) {
+	transitMu.Lock()
+	defer transitMu.Unlock()
+
+	if len(blocksInTransit) == 0 {
+		return nil, false
+	}
+	blockHash := blocksInTransit[0]
+	blocksInTransit = blocksInTransit[1:]
+
+	return blockHash, true
+}
+
 func HandleGetBlocks(request []byte, chain *blockchain.BlockChain, remoteIP string) {
 	var buff bytes.Buffer
 	var payload GetBlocks
@@ -351,7 +421,12 @@ func HandleGetData(request []byte, chain *blockchain.BlockChain, remoteIP string
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txID]
+		poolMu.Lock()
+		tx, ok := memoryPool[txID]
+		poolMu.Unlock()
+		if !ok {
+			return
+		}
 
 		SendTx(fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom), &tx)
 	}
@@ -365,19 +440,44 @@ func HandleTx(request []byte, chain *blockchain.BlockChain, remoteIP string) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		fmt.Printf("Undecodable tx message from %s: %s\n", remoteIP, err)
+		Misbehaving(remoteIP, BanThreshold, err.Error())
+		return
 	}
 
-	txData := payload.Transaction
-	tx := blockchain.DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
+	if IsBanned(remoteIP) {
+		return
+	}
 
-	fmt.Printf("%s, %d\n", NodeAddress, len(memoryPool))
+	tx, err := blockchain.DecodeTransaction(payload.Transaction)
+	if err != nil {
+		fmt.Printf("Undecodable tx from %s: %s\n", remoteIP, err)
+		Misbehaving(remoteIP, BanThreshold, err.Error())
+		return
+	}
 
-	if len(memoryPool) >= 2 && len(mineAddress) > 0 {
-		MineTx(chain)
+	added, err := AddToMemoryPool(chain, &tx)
+	if err != nil {
+		fmt.Printf("Rejected tx %x: %s\n", tx.ID, err)
+		// Inputs that went missing or got spent may be a race with a
+		// block, anything else the peer should have checked.
+		if !errors.Is(err, blockchain.ErrMissingInput) && !errors.Is(err, ErrPoolConflict) {
+			Misbehaving(remoteIP, BanThreshold, err.Error())
+		}
+		return
+	}
+	if !added {
+		return
 	}
 
+	poolMu.Lock()
+	poolSize := len(memoryPool)
+	poolMu.Unlock()
+
+	fmt.Printf("%s, %d\n", NodeAddress, poolSize)
+
+	TxAdded()
+
 	// if nodeAddress == KnownNodes[0] {
 	for _, node := range KnownNodes {
 		if node != NodeAddress && node != fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom) {
@@ -387,46 +487,65 @@ func HandleTx(request []byte, chain *blockchain.BlockChain, remoteIP string) {
 	// }
 }
 
-func MineTx(chain *blockchain.BlockChain) {
-	var txs []*blockchain.Transaction
-
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", hex.EncodeToString(memoryPool[id].ID))
-		tx := memoryPool[id]
-		// if chain.VerifyTransaction(&tx) {
-		txs = append(txs, &tx)
-		// }
+// MineTx mines one block from the memory pool if mining is on and
+// MinePolicy allows it. It reports whether the miner should go again:
+// either a block was mined and the policy mines more than one per wake-up,
+// or mining was aborted by a new tip or a stale template.
+func MineTx(chain *blockchain.BlockChain) bool {
+	address, on := Generating()
+	if !on {
+		return false
 	}
 
-	UTXOSet := blockchain.UTXOSet{chain}
+	ctx, done := newMiningContext()
+	defer done()
 
-	if !blockchain.CheckTransactions(txs, &UTXOSet) {
-		for _, tx := range txs {
-			txID := hex.EncodeToString(tx.ID)
-			delete(memoryPool, txID)
-		}
-		txs = []*blockchain.Transaction{}
+	tmpl, err := chain.NewBlockTemplate(minablePool(chain))
+	if err != nil {
+		fmt.Printf("Could not build block template: %s\n", err)
+		return false
 	}
 
-	if len(txs) == 0 {
-		fmt.Println("All Transactions are invalid")
-		return
+	if !readyToMine(tmpl) {
+		fmt.Printf("Waiting for transactions: %d ready paying %d fees\n", len(tmpl.Transactions), tmpl.Fees)
+		return false
 	}
 
-	cbTx := blockchain.CoinbaseTx(mineAddress, "")
-	txs = append(txs, cbTx)
+	_, err = mineTemplate(ctx, chain, tmpl, address)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, blockchain.ErrStaleTip) {
+		fmt.Println("Mining aborted, rebuilding the block template")
+		return true
+	}
+	if err != nil {
+		fmt.Printf("Could not mine block: %s\n", err)
+		return false
+	}
 
-	newBlock := chain.MineBlock(txs)
-	UTXOSet.Reindex()
+	return MinePolicy != MineInterval
+}
 
-	fmt.Println("New Block mined")
+// mineTemplate mines a block from tmpl paying address. Once the block has
+// become the tip, the memory pool follows the chain and the block is
+// announced to the known nodes. A block that lost the race to another one
+// is left on its side branch and blockchain.ErrStaleTip returned.
+func mineTemplate(ctx context.Context, chain *blockchain.BlockChain, tmpl *blockchain.BlockTemplate, address string) (*blockchain.Block, error) {
+	cbTx := blockchain.CoinbaseTx(address, "", tmpl.CoinbaseValue)
+	txs := append([]*blockchain.Transaction{cbTx}, tmpl.Transactions...)
 
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txID)
+	newBlock, update, err := chain.MineBlock(ctx, txs)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("%s, %d\n", NodeAddress, len(memoryPool))
+	fmt.Printf("New Block mined with %d transactions, %d fees, %d bytes\n", len(tmpl.Transactions), tmpl.Fees, newBlock.Size())
+
+	UpdateMemoryPool(update)
+
+	poolMu.Lock()
+	remaining := len(memoryPool)
+	poolMu.Unlock()
+
+	fmt.Printf("%s, %d\n", NodeAddress, remaining)
 
 	for _, node := range KnownNodes {
 		if node != NodeAddress {
@@ -434,9 +553,97 @@ func MineTx(chain *blockchain.BlockChain) {
 		}
 	}
 
-	if len(memoryPool) > 0 {
-		MineTx(chain)
+	return newBlock, nil
+}
+
+// minablePool prunes the memory pool and returns a copy of what is left.
+func minablePool(chain *blockchain.BlockChain) []*blockchain.Transaction {
+	var pool []*blockchain.Transaction
+
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	PruneMemoryPool(chain)
+
+	for id := range memoryPool {
+		fmt.Printf("tx: %s\n", hex.EncodeToString(memoryPool[id].ID))
+		tx := memoryPool[id]
+		pool = append(pool, &tx)
+	}
+
+	return pool
+}
+
+// PruneMemoryPool drops transactions that can no longer be mined on the
+// current tip. Transactions spending the output of another pool transaction
+// are checked against it, and dropped along with it. The caller must hold
+// poolMu.
+func PruneMemoryPool(chain *blockchain.BlockChain) {
+	for dropped := true; dropped; {
+		dropped = false
+		outputs := poolOutputs()
+
+		for id, tx := range memoryPool {
+			err := chain.VerifyPoolTransaction(&tx, outputs)
+			if err == nil || errors.Is(err, blockchain.ErrNonFinalTx) {
+				continue
+			}
+
+			fmt.Printf("Dropping tx %s: %s\n", id, err)
+			delete(memoryPool, id)
+			dropped = true
+		}
+	}
+}
+
+// ErrPoolConflict is returned by AddToMemoryPool for a transaction that
+// spends an output a pooled transaction already spends.
+var ErrPoolConflict = errors.New("output is already spent in the memory pool")
+
+// AddToMemoryPool verifies tx against the chain and the memory pool and
+// adds it. The first of two transactions spending the same output stays.
+// Transactions that are not final yet are kept for later. It reports
+// whether tx was new.
+func AddToMemoryPool(chain *blockchain.BlockChain, tx *blockchain.Transaction) (bool, error) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if _, ok := memoryPool[hex.EncodeToString(tx.ID)]; ok {
+		return false, nil
+	}
+
+	for _, pooled := range memoryPool {
+		for _, in := range pooled.Inputs {
+			for _, txIn := range tx.Inputs {
+				if bytes.Equal(in.ID, txIn.ID) && in.Out == txIn.Out {
+					return false, fmt.Errorf("%w: %x:%d", ErrPoolConflict, in.ID, in.Out)
+				}
+			}
+		}
 	}
+
+	err := chain.VerifyPoolTransaction(tx, poolOutputs())
+	if err != nil && !errors.Is(err, blockchain.ErrNonFinalTx) {
+		return false, err
+	}
+
+	memoryPool[hex.EncodeToString(tx.ID)] = *tx
+
+	return true, nil
+}
+
+// poolOutputs returns the outputs of the pooled transactions keyed by
+// outpoint. The caller must hold poolMu.
+func poolOutputs() map[string]blockchain.TxOutput {
+	outputs := make(map[string]blockchain.TxOutput)
+
+	for _, tx := range memoryPool {
+		for outIdx, out := range tx.Outputs {
+			outputs[fmt.Sprintf("%x:%d", tx.ID, outIdx)] = out
+		}
+	}
+
+	return outputs
 }
 
 func HandleVersion(request []byte, chain *blockchain.BlockChain, remoteIP string) {
@@ -450,11 +657,24 @@ func HandleVersion(request []byte, chain *blockchain.BlockChain, remoteIP string
 		log.Panic(err)
 	}
 
+	if IsBanned(remoteIP) {
+		return
+	}
+
+	if hex.EncodeToString(payload.GenesisHash) != blockchain.ActiveNetParams.GenesisHash {
+		Misbehaving(remoteIP, BanThreshold, fmt.Sprintf("genesis block %x differs", payload.GenesisHash))
+		return
+	}
+
 	bestHeight := chain.GetBestHeight()
 	otherHeight := payload.BestHeight
 
 	fmt.Printf("AddrFrom: %s\n", fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom))
 
+	if payload.Timestamp != 0 && remoteIP != "" {
+		blockchain.AddTimeSample(remoteIP, payload.Timestamp)
+	}
+
 	if bestHeight < otherHeight {
 		SendGetBlocks(payload.AddrFrom)
 	} else if bestHeight > otherHeight {
@@ -466,20 +686,18 @@ func HandleVersion(request []byte, chain *blockchain.BlockChain, remoteIP string
 	}
 }
 
-func HandleBootnode(request []byte, chain *blockchain.BlockChain, remoteIP string) {
-	var buff bytes.Buffer
-	var payload Version
+// localCommands change what the node mines, so they are only served to
+// clients on the same host.
+var localCommands = map[string]bool{
+	"setgenerate": true,
+	"generate":    true,
+	"submitblock": true,
+}
 
-	buff.Write(request[CommandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
+func isLoopback(remoteIP string) bool {
+	ip := net.ParseIP(remoteIP)
 
-	fmt.Printf("AddrFrom: %s\n", fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom))
-	genesisBlock := chain.GetGenesisBlock()
-	SendGenesis(fmt.Sprintf("%s%s", remoteIP, payload.AddrFrom), &genesisBlock)
+	return ip != nil && ip.IsLoopback()
 }
 
 func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
@@ -499,6 +717,11 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 	command := BytesToCmd(req[:CommandLength])
 	fmt.Printf("Received %s command\n", command)
 
+	if localCommands[command] && !isLoopback(remoteIP) {
+		fmt.Printf("Refusing %s from %s\n", command, remoteIP)
+		return
+	}
+
 	switch command {
 	case "addr":
 		HandleAddr(req)
@@ -514,8 +737,18 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 		HandleTx(req, chain, remoteIP)
 	case "version":
 		HandleVersion(req, chain, remoteIP)
-	case "bootnode":
-		HandleBootnode(req, chain, remoteIP)
+	case "gettemplate":
+		HandleGetTemplate(conn, chain)
+	case "submitblock":
+		HandleSubmitBlock(conn, req, chain, remoteIP)
+	case "mininginfo":
+		HandleMiningInfo(conn, chain)
+	case "setgenerate":
+		HandleSetGenerate(conn, req)
+	case "generate":
+		HandleGenerate(conn, req, chain)
+	case "spendinginfo":
+		HandleSpendingInfo(conn, req, chain)
 	default:
 		fmt.Println("Unknown command")
 	}
@@ -524,9 +757,8 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 
 func StartServer(nodeID, minerAddress, bootnode string) {
 	NodeAddress = fmt.Sprintf(":%s", nodeID)
-	mineAddress = minerAddress
 
-	path := fmt.Sprintf(blockchain.DbPath, nodeID)
+	path := blockchain.DBPath(nodeID)
 	var chain *blockchain.BlockChain
 
 	ln, err := net.Listen(Protocol, NodeAddress)
@@ -535,46 +767,28 @@ func StartServer(nodeID, minerAddress, bootnode string) {
 	}
 	defer ln.Close()
 
-	if bootnode == "" {
-		if blockchain.DBexists(path) {
-			chain = blockchain.ResumeBlockChain(nodeID)
-			fmt.Println("Resumed chain")
-		} else if minerAddress != "" {
-			chain = blockchain.InitBlockChain(minerAddress, nodeID)
-			fmt.Printf("Created new chain with %s\n", minerAddress)
-		} else {
-			fmt.Println("Must have minerAddr or init blockchain b4 or set bootnode")
-			runtime.Goexit()
-		}
-	} else if !blockchain.DBexists(path) {
-
-		KnownNodes[0] = bootnode
-
-		SendBootnode(bootnode)
-
-		conn, err := ln.Accept()
-		blockchain.Handle(err)
-
-		req, err := ioutil.ReadAll(conn)
-		conn.Close()
-		blockchain.Handle(err)
-
-		command := BytesToCmd(req[:CommandLength])
-		fmt.Printf("Received %s command\n", command)
-
-		chain = HandleGenesis(req)
+	if blockchain.DBexists(path) {
+		chain = blockchain.ResumeBlockChain(nodeID)
+		fmt.Println("Resumed chain")
 	} else {
-		fmt.Println("To set bootnode must not have blockchain")
-		runtime.Goexit()
+		chain = blockchain.InitBlockChain(nodeID)
+		fmt.Printf("Created new %s chain\n", blockchain.ActiveNetParams.Name)
 	}
 
-	defer chain.Database.Close()
+	defer chain.Store.Close()
 	go CloseDB(chain)
 
-	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	UTXOSet.Reindex()
 
+	StartMiner(chain)
+	if minerAddress != "" {
+		err := SetGenerate(true, minerAddress)
+		blockchain.Handle(err)
+	}
+
 	if bootnode != "" {
+		KnownNodes[0] = bootnode
 		SendVersion(KnownNodes[0], chain)
 	}
 
@@ -614,6 +828,6 @@ func CloseDB(chain *blockchain.BlockChain) {
 	d.WaitForDeathWithFunc(func() {
 		defer os.Exit(1)
 		defer runtime.Goexit()
-		chain.Database.Close()
+		chain.Store.Close()
 	})
 }
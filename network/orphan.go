@@ -0,0 +1,136 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/patiparnphot/decentralize-utxos-blockchain/blockchain"
+)
+
+const (
+	MaxOrphanBlocks = 100
+	// MaxOrphansPerPeer keeps one peer from filling the orphan pool.
+	MaxOrphansPerPeer = 20
+	OrphanExpiry      = 10 * time.Minute
+)
+
+// orphanBlock is a block received before its parent.
+type orphanBlock struct {
+	block    *blockchain.Block
+	remoteIP string
+	expires  time.Time
+}
+
+var (
+	orphans     = make(map[string]*orphanBlock)
+	orphansLock sync.Mutex
+)
+
+// AddOrphan keeps block until its parent arrives and returns the hash of the
+// oldest ancestor that is still missing, which is the one to ask peers for.
+// The block must have passed CheckOrphan, so its hash is its own. A peer at
+// MaxOrphansPerPeer makes room by losing its own oldest orphan.
+func AddOrphan(block *blockchain.Block, remoteIP string) []byte {
+	orphansLock.Lock()
+	defer orphansLock.Unlock()
+
+	expireOrphans()
+
+	id := hex.EncodeToString(block.Hash)
+	if _, ok := orphans[id]; !ok {
+		if countOrphans(remoteIP) >= MaxOrphansPerPeer {
+			evictOrphan(remoteIP)
+		} else if len(orphans) >= MaxOrphanBlocks {
+			evictOrphan("")
+		}
+		orphans[id] = &orphanBlock{block, remoteIP, time.Now().Add(OrphanExpiry)}
+	}
+
+	missing := block.PrevHash
+	for {
+		orphan, ok := orphans[hex.EncodeToString(missing)]
+		if !ok {
+			return missing
+		}
+		missing = orphan.block.PrevHash
+	}
+}
+
+// ProcessOrphans connects every orphan that descends from the block hash,
+// which has just been added to the chain.
+func ProcessOrphans(chain *blockchain.BlockChain, hash []byte) {
+	parents := [][]byte{hash}
+
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+
+		for _, orphan := range takeOrphans(parent) {
+			fmt.Printf("Connecting orphan block %x\n", orphan.block.Hash)
+
+			if AcceptBlock(chain, orphan.block, orphan.remoteIP) == nil {
+				parents = append(parents, orphan.block.Hash)
+			}
+		}
+	}
+}
+
+// takeOrphans removes and returns the orphans whose parent is parentHash.
+func takeOrphans(parentHash []byte) []*orphanBlock {
+	orphansLock.Lock()
+	defer orphansLock.Unlock()
+
+	expireOrphans()
+
+	var children []*orphanBlock
+	for id, orphan := range orphans {
+		if bytes.Equal(orphan.block.PrevHash, parentHash) {
+			children = append(children, orphan)
+			delete(orphans, id)
+		}
+	}
+
+	return children
+}
+
+func expireOrphans() {
+	now := time.Now()
+
+	for id, orphan := range orphans {
+		if now.After(orphan.expires) {
+			fmt.Printf("Orphan block %x expired\n", orphan.block.Hash)
+			delete(orphans, id)
+		}
+	}
+}
+
+func countOrphans(remoteIP string) int {
+	count := 0
+	for _, orphan := range orphans {
+		if orphan.remoteIP == remoteIP {
+			count++
+		}
+	}
+
+	return count
+}
+
+// evictOrphan drops the orphan closest to expiry to make room for a new one,
+// only looking at those from remoteIP unless it is empty.
+func evictOrphan(remoteIP string) {
+	var oldest string
+
+	for id, orphan := range orphans {
+		if remoteIP != "" && orphan.remoteIP != remoteIP {
+			continue
+		}
+		if oldest == "" || orphan.expires.Before(orphans[oldest].expires) {
+			oldest = id
+		}
+	}
+
+	delete(orphans, oldest)
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// BanThreshold is the misbehaviour score at which a peer gets banned.
+	BanThreshold = 100
+	BanDuration  = 24 * time.Hour
+)
+
+var (
+	banScores   = make(map[string]int)
+	bannedPeers = make(map[string]time.Time)
+	peersLock   sync.Mutex
+)
+
+// Misbehaving raises the score of a peer that relayed invalid data and bans
+// it once the score reaches BanThreshold. Peers are scored by remote IP, as
+// the address they announce is theirs to choose.
+func Misbehaving(remoteIP string, howMuch int, reason string) {
+	if remoteIP == "" {
+		return
+	}
+
+	peersLock.Lock()
+	defer peersLock.Unlock()
+
+	banScores[remoteIP] += howMuch
+	fmt.Printf("Peer %s misbehaving (%d): %s\n", remoteIP, banScores[remoteIP], reason)
+
+	if banScores[remoteIP] >= BanThreshold {
+		fmt.Printf("Banning peer %s\n", remoteIP)
+		bannedPeers[remoteIP] = time.Now().Add(BanDuration)
+		delete(banScores, remoteIP)
+
+		var updatedNodes []string
+		for _, node := range KnownNodes {
+			if host, _, err := net.SplitHostPort(node); err != nil || host != remoteIP {
+				updatedNodes = append(updatedNodes, node)
+			}
+		}
+		KnownNodes = updatedNodes
+	}
+}
+
+func IsBanned(remoteIP string) bool {
+	peersLock.Lock()
+	defer peersLock.Unlock()
+
+	until, ok := bannedPeers[remoteIP]
+	if ok && time.Now().After(until) {
+		delete(bannedPeers, remoteIP)
+		return false
+	}
+
+	return ok
+}
@@ -0,0 +1,480 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+
+	"github.com/patiparnphot/decentralize-utxos-blockchain/blockchain"
+)
+
+// Template is the reply to gettemplate: everything an external miner needs
+// to build and solve the next block. Error is set when no template could be
+// built.
+type Template struct {
+	Error         string
+	Version       int
+	PrevHash      []byte
+	Height        int
+	Bits          uint32
+	Target        []byte
+	MinTime       int64
+	CurTime       int64
+	CoinbaseValue int
+	Fees          int
+	Transactions  [][]byte
+}
+
+// NetworkHashRateBlocks is how many recent blocks getmininginfo estimates
+// the network hash rate from.
+const NetworkHashRateBlocks = 120
+
+// MiningInfo is the reply to mininginfo.
+type MiningInfo struct {
+	Error           string
+	Blocks          int
+	Bits            uint32
+	Difficulty      float64
+	LocalHashRate   float64
+	NetworkHashRate float64
+	PooledTx        int
+	Generating      bool
+}
+
+type SetGenerateRequest struct {
+	On      bool
+	Address string
+}
+
+// SetGenerateResult is the reply to setgenerate.
+type SetGenerateResult struct {
+	Generating bool
+	Address    string
+	Error      string
+}
+
+type Generate struct {
+	Blocks  int
+	Address string
+}
+
+// GenerateResult is the reply to generate: the hashes of the blocks mined,
+// in order.
+type GenerateResult struct {
+	Hashes [][]byte
+	Error  string
+}
+
+type SubmitBlock struct {
+	Block []byte
+}
+
+// SubmitResult is the reply to submitblock. Error says why the block was
+// rejected.
+type SubmitResult struct {
+	Accepted bool
+	Error    string
+}
+
+type SpendingInfoRequest struct {
+	TxID []byte
+	Vout int
+}
+
+// SpendingInfoResult is the reply to spendinginfo. Mempool is set when the
+// spender is still in the memory pool, which leaves BlockHash empty.
+// Unconfirmed is set when the output itself belongs to a pooled transaction.
+type SpendingInfoResult struct {
+	Spent       bool
+	Mempool     bool
+	Unconfirmed bool
+	TxID        []byte
+	Input       int
+	BlockHash   []byte
+	Height      int
+	Error       string
+}
+
+// SendRequest sends a request on a new connection and returns the reply the
+// node writes back on it. The write side is closed after the request, which
+// is how the node knows it has all of it.
+func SendRequest(addr string, request []byte) ([]byte, error) {
+	conn, err := net.Dial(Protocol, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := tcp.CloseWrite(); err != nil {
+			return nil, err
+		}
+	}
+
+	return ioutil.ReadAll(conn)
+}
+
+// GetBlockTemplate asks the node at addr for a block template.
+func GetBlockTemplate(addr string) (*Template, error) {
+	reply, err := SendRequest(addr, CmdToBytes("gettemplate"))
+	if err != nil {
+		return nil, err
+	}
+
+	var tmpl Template
+	if err := gob.NewDecoder(bytes.NewReader(reply)).Decode(&tmpl); err != nil {
+		return nil, err
+	}
+	if tmpl.Error != "" {
+		return nil, errors.New(tmpl.Error)
+	}
+
+	return &tmpl, nil
+}
+
+// SubmitSolvedBlock hands a solved block to the node at addr, which
+// validates and connects it.
+func SubmitSolvedBlock(addr string, block *blockchain.Block) error {
+	payload := GobEncode(SubmitBlock{block.Serialize()})
+	reply, err := SendRequest(addr, append(CmdToBytes("submitblock"), payload...))
+	if err != nil {
+		return err
+	}
+
+	var result SubmitResult
+	if err := gob.NewDecoder(bytes.NewReader(reply)).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Accepted {
+		return errors.New(result.Error)
+	}
+
+	return nil
+}
+
+// GetMiningInfo asks the node at addr about its mining and the network.
+func GetMiningInfo(addr string) (*MiningInfo, error) {
+	reply, err := SendRequest(addr, CmdToBytes("mininginfo"))
+	if err != nil {
+		return nil, err
+	}
+
+	var info MiningInfo
+	if err := gob.NewDecoder(bytes.NewReader(reply)).Decode(&info); err != nil {
+		return nil, err
+	}
+	if info.Error != "" {
+		return nil, errors.New(info.Error)
+	}
+
+	return &info, nil
+}
+
+// SendSetGenerate turns mining on the node at addr on or off. An empty
+// address keeps the one the node mined to before.
+func SendSetGenerate(addr string, on bool, address string) (*SetGenerateResult, error) {
+	payload := GobEncode(SetGenerateRequest{on, address})
+	reply, err := SendRequest(addr, append(CmdToBytes("setgenerate"), payload...))
+	if err != nil {
+		return nil, err
+	}
+
+	var result SetGenerateResult
+	if err := gob.NewDecoder(bytes.NewReader(reply)).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+
+	return &result, nil
+}
+
+// SendGenerate has the regtest node at addr mine blocks paying address.
+func SendGenerate(addr string, blocks int, address string) ([][]byte, error) {
+	payload := GobEncode(Generate{blocks, address})
+	reply, err := SendRequest(addr, append(CmdToBytes("generate"), payload...))
+	if err != nil {
+		return nil, err
+	}
+
+	var result GenerateResult
+	if err := gob.NewDecoder(bytes.NewReader(reply)).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return result.Hashes, errors.New(result.Error)
+	}
+
+	return result.Hashes, nil
+}
+
+// GetSpendingInfo asks the node at addr what spent output vout of txID,
+// looking in its memory pool as well as in the chain.
+func GetSpendingInfo(addr string, txID []byte, vout int) (*SpendingInfoResult, error) {
+	payload := GobEncode(SpendingInfoRequest{txID, vout})
+	reply, err := SendRequest(addr, append(CmdToBytes("spendinginfo"), payload...))
+	if err != nil {
+		return nil, err
+	}
+
+	var result SpendingInfoResult
+	if err := gob.NewDecoder(bytes.NewReader(reply)).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+
+	return &result, nil
+}
+
+// BlockTemplate turns the reply back into the template it was built from.
+func (tmpl *Template) BlockTemplate() *blockchain.BlockTemplate {
+	blockTmpl := &blockchain.BlockTemplate{
+		PrevHash:      tmpl.PrevHash,
+		Height:        tmpl.Height,
+		Bits:          tmpl.Bits,
+		MinTime:       tmpl.MinTime,
+		Fees:          tmpl.Fees,
+		CoinbaseValue: tmpl.CoinbaseValue,
+	}
+	for _, data := range tmpl.Transactions {
+		tx := blockchain.DeserializeTransaction(data)
+		blockTmpl.Transactions = append(blockTmpl.Transactions, &tx)
+	}
+
+	return blockTmpl
+}
+
+func HandleGetTemplate(conn net.Conn, chain *blockchain.BlockChain) {
+	var reply Template
+
+	blockTmpl, err := chain.NewBlockTemplate(minablePool(chain))
+	if err != nil {
+		reply.Error = err.Error()
+	} else {
+		reply = Template{
+			Version:       blockchain.BlockVersion,
+			PrevHash:      blockTmpl.PrevHash,
+			Height:        blockTmpl.Height,
+			Bits:          blockTmpl.Bits,
+			Target:        blockchain.CompactToBig(blockTmpl.Bits).Bytes(),
+			MinTime:       blockTmpl.MinTime,
+			CurTime:       blockchain.AdjustedTime(),
+			CoinbaseValue: blockTmpl.CoinbaseValue,
+			Fees:          blockTmpl.Fees,
+		}
+		for _, tx := range blockTmpl.Transactions {
+			reply.Transactions = append(reply.Transactions, tx.Serialize())
+		}
+	}
+
+	if _, err := conn.Write(GobEncode(reply)); err != nil {
+		fmt.Printf("Could not send block template: %s\n", err)
+	}
+}
+
+// HandleSubmitBlock connects a block solved by an external miner and
+// announces it to the known nodes. A submitter at remoteIP that sends an
+// invalid block is penalized like any peer.
+func HandleSubmitBlock(conn net.Conn, request []byte, chain *blockchain.BlockChain, remoteIP string) {
+	var buff bytes.Buffer
+	var payload SubmitBlock
+
+	buff.Write(request[CommandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	block := blockchain.Deserialize(payload.Block)
+	fmt.Printf("Received submitted block %x\n", block.Hash)
+
+	var result SubmitResult
+	if IsBanned(remoteIP) {
+		result.Error = "submitter is banned"
+	} else if !chain.HasBlock(block.PrevHash) {
+		result.Error = fmt.Sprintf("parent block %x is not found", block.PrevHash)
+	} else if err := AcceptBlock(chain, block, remoteIP); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Accepted = true
+		ProcessOrphans(chain, block.Hash)
+
+		for _, node := range KnownNodes {
+			if node != NodeAddress {
+				SendInv(node, "block", [][]byte{block.Hash})
+			}
+		}
+	}
+
+	if _, err := conn.Write(GobEncode(result)); err != nil {
+		fmt.Printf("Could not send submit result: %s\n", err)
+	}
+}
+
+func HandleMiningInfo(conn net.Conn, chain *blockchain.BlockChain) {
+	var info MiningInfo
+
+	tip, err := chain.GetHeader(chain.Tip())
+	if err == nil {
+		info.Blocks = tip.Height
+		info.Bits = tip.Bits
+		info.Difficulty = blockchain.GetDifficulty(tip.Bits)
+		info.NetworkHashRate, err = chain.NetworkHashRate(NetworkHashRateBlocks)
+	}
+	if err != nil {
+		info.Error = err.Error()
+	}
+
+	info.LocalHashRate = blockchain.LocalHashRate()
+	_, info.Generating = Generating()
+
+	poolMu.Lock()
+	info.PooledTx = len(memoryPool)
+	poolMu.Unlock()
+
+	if _, err := conn.Write(GobEncode(info)); err != nil {
+		fmt.Printf("Could not send mining info: %s\n", err)
+	}
+}
+
+func HandleSetGenerate(conn net.Conn, request []byte) {
+	var buff bytes.Buffer
+	var payload SetGenerateRequest
+
+	buff.Write(request[CommandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var result SetGenerateResult
+	if err := SetGenerate(payload.On, payload.Address); err != nil {
+		result.Error = err.Error()
+	}
+	result.Address, result.Generating = Generating()
+
+	if _, err := conn.Write(GobEncode(result)); err != nil {
+		fmt.Printf("Could not send setgenerate result: %s\n", err)
+	}
+}
+
+// HandleGenerate mines the requested number of blocks right away, taking
+// transactions from the memory pool. Only regtest allows it, since its
+// target makes every block instant.
+func HandleGenerate(conn net.Conn, request []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload Generate
+
+	buff.Write(request[CommandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var result GenerateResult
+	if blockchain.ActiveNetParams.Name != blockchain.RegTestParams.Name {
+		result.Error = fmt.Sprintf("generate is only allowed on %s", blockchain.RegTestParams.Name)
+	}
+
+	for len(result.Hashes) < payload.Blocks && result.Error == "" {
+		tmpl, err := chain.NewBlockTemplate(minablePool(chain))
+		if err == nil {
+			var block *blockchain.Block
+			block, err = mineTemplate(context.Background(), chain, tmpl, payload.Address)
+			if err == nil {
+				result.Hashes = append(result.Hashes, block.Hash)
+				TipChanged()
+			}
+		}
+		// A block that arrived meanwhile took the tip, mine on top of it.
+		if err != nil && !errors.Is(err, blockchain.ErrStaleTip) {
+			result.Error = err.Error()
+		}
+	}
+
+	if _, err := conn.Write(GobEncode(result)); err != nil {
+		fmt.Printf("Could not send generated blocks: %s\n", err)
+	}
+}
+
+// FindSpendingInfo looks up what spent output vout of txID. A spend in the
+// chain wins over one in the memory pool, and outputs of pooled
+// transactions are only looked up in the pool. The pool is pruned first, so
+// only spenders that still verify on the tip are reported.
+func FindSpendingInfo(chain *blockchain.BlockChain, txID []byte, vout int) SpendingInfoResult {
+	var result SpendingInfoResult
+
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	PruneMemoryPool(chain)
+
+	if tx, ok := memoryPool[hex.EncodeToString(txID)]; ok {
+		if vout < 0 || vout >= len(tx.Outputs) {
+			result.Error = fmt.Sprintf("pooled transaction %x has no output %d", txID, vout)
+			return result
+		}
+		result.Unconfirmed = true
+	} else {
+		info, err := chain.FindSpender(txID, vout)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if info != nil {
+			result.Spent = true
+			result.TxID = info.TxID
+			result.Input = info.Input
+			result.BlockHash = info.BlockHash
+			result.Height = info.Height
+			return result
+		}
+	}
+
+	for _, tx := range memoryPool {
+		for i, in := range tx.Inputs {
+			if bytes.Equal(in.ID, txID) && in.Out == vout {
+				result.Spent = true
+				result.Mempool = true
+				result.TxID = tx.ID
+				result.Input = i
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+func HandleSpendingInfo(conn net.Conn, request []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload SpendingInfoRequest
+
+	buff.Write(request[CommandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	result := FindSpendingInfo(chain, payload.TxID, payload.Vout)
+
+	if _, err := conn.Write(GobEncode(result)); err != nil {
+		fmt.Printf("Could not send spending info: %s\n", err)
+	}
+}